@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"goeule/eule"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"time"
+)
+
+var errRegression = errors.New("benchmark regression")
+
+// benchResult is the per operation cost of one benchmark file, stored as is
+// in baseline files.
+type benchResult struct {
+	Runs         int     `json:"runs"`
+	NsPerOp      float64 `json:"ns_per_op"`
+	StdDev       float64 `json:"stddev"`
+	MinNs        float64 `json:"min_ns"`
+	AllocsPerOp  uint64  `json:"allocs_per_op"`
+	BytesPerOp   uint64  `json:"bytes_per_op"`
+	Instructions uint64  `json:"instructions_per_op"`
+}
+
+func runBench(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	runs := flags.Int("n", 5, "measured `runs` per benchmark")
+	warmup := flags.Int("warmup", 1, "unmeasured warmup `runs`")
+	baseline := flags.String("baseline", "", "compare against baseline `file`")
+	save := flags.String("save", "", "save results as baseline `file`")
+	threshold := flags.Float64("threshold", 10, "regression threshold in `percent`")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if *runs < 1 {
+		return fmt.Errorf("%w: -n must be positive", errUsage)
+	}
+	if *warmup < 0 {
+		return fmt.Errorf("%w: -warmup must not be negative", errUsage)
+	}
+
+	var paths []string
+	roots := flags.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	for _, root := range roots {
+		found, err := eule.FindTestCases(root)
+		if err != nil {
+			return fmt.Errorf("bench: %w", err)
+		}
+		paths = append(paths, found...)
+	}
+
+	var base map[string]benchResult
+	if *baseline != "" {
+		data, err := os.ReadFile(*baseline)
+		if err != nil {
+			return fmt.Errorf("bench: %w", err)
+		}
+		if err := json.Unmarshal(data, &base); err != nil {
+			return fmt.Errorf("bench: %s: %w", *baseline, err)
+		}
+	}
+
+	results := map[string]benchResult{}
+	regressions := 0
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("bench: %w", err)
+		}
+		r, err := benchmark(path, source, *runs, *warmup)
+		if err != nil {
+			return fmt.Errorf("bench: %s: %w", path, err)
+		}
+		name := filepath.ToSlash(path)
+		results[name] = r
+		fmt.Printf(
+			"%-32s %3d runs %14s/op ±%5.1f%%  min %-12s %8d allocs/op %10d B/op %12d instr/op\n",
+			name, r.Runs,
+			time.Duration(r.NsPerOp), 100*r.StdDev/r.NsPerOp,
+			time.Duration(r.MinNs), r.AllocsPerOp, r.BytesPerOp, r.Instructions,
+		)
+		if old, ok := base[name]; ok {
+			if compareBench(old, r, *threshold) {
+				regressions++
+			}
+		}
+	}
+
+	if *save != "" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return fmt.Errorf("bench: %w", err)
+		}
+		if err := os.WriteFile(*save, append(data, '\n'), 0o644); err != nil {
+			return fmt.Errorf("bench: %w", err)
+		}
+	}
+	if regressions != 0 {
+		return fmt.Errorf("%w: %d benchmarks slower than baseline", errRegression, regressions)
+	}
+	return nil
+}
+
+// benchmark runs source, read from path, warmup+runs times, each in a
+// fresh VM, and measures the runs after the warmup.
+func benchmark(path string, source []byte, runs, warmup int) (benchResult, error) {
+	var times []float64
+	var allocs, bytes, instructions uint64
+	for i := range warmup + runs {
+		vm := eule.New(
+			eule.WithStdout(io.Discard),
+			eule.WithGlobalLibs(),
+			eule.WithModules(eule.FSLoader(os.DirFS(filepath.Dir(path)))),
+		)
+		before := vm.Instructions()
+
+		runtime.GC()
+		var m0, m1 runtime.MemStats
+		runtime.ReadMemStats(&m0)
+		start := time.Now()
+		err := vm.Interpret(source)
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&m1)
+		if err != nil {
+			return benchResult{}, err
+		}
+		if i < warmup {
+			continue
+		}
+
+		times = append(times, float64(elapsed.Nanoseconds()))
+		allocs += m1.Mallocs - m0.Mallocs
+		bytes += m1.TotalAlloc - m0.TotalAlloc
+		instructions += vm.Instructions() - before
+	}
+
+	n := float64(runs)
+	var mean, variance float64
+	for _, t := range times {
+		mean += t / n
+	}
+	for _, t := range times {
+		variance += (t - mean) * (t - mean) / n
+	}
+	return benchResult{
+		Runs:         runs,
+		NsPerOp:      mean,
+		StdDev:       math.Sqrt(variance),
+		MinNs:        slices.Min(times),
+		AllocsPerOp:  allocs / uint64(runs),
+		BytesPerOp:   bytes / uint64(runs),
+		Instructions: instructions / uint64(runs),
+	}, nil
+}
+
+// compareBench prints the change against the baseline and reports whether
+// the time per operation regressed by more than threshold percent.
+func compareBench(old, new benchResult, threshold float64) bool {
+	delta := 100 * (new.NsPerOp - old.NsPerOp) / old.NsPerOp
+	verdict := "ok"
+	regressed := delta > threshold
+	if regressed {
+		verdict = "REGRESSION"
+	}
+	fmt.Printf("  vs baseline: time %+.1f%%, allocs %+d, instr %+d  %s\n",
+		delta,
+		int64(new.AllocsPerOp)-int64(old.AllocsPerOp),
+		int64(new.Instructions)-int64(old.Instructions),
+		verdict,
+	)
+	return regressed
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"goeule/eule"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errUsage = errors.New("usage")
+	// errFlags is returned after the flag package reported a bad flag
+	errFlags = errors.New("invalid flags")
+)
+
+var commands = map[string]func(args []string) error{
+	"run":     runFile,
+	"disasm":  runDisasm,
+	"tokens":  runTokens,
+	"compile": runCompile,
+	"test":    runTest,
+	"bench":   runBench,
+	"fmt":     runFmt,
+	"lint":    runLint,
+	"lsp":     runLsp,
+	"debug":   runDebug,
+}
+
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errFlags
+	}
+	return nil
+}
+
+// parseCommand parses flags of a subcommand expecting exactly one script.
+func parseCommand(flags *flag.FlagSet, args []string) (string, []byte, error) {
+	if err := parseFlags(flags, args); err != nil {
+		return "", nil, err
+	}
+	if flags.NArg() != 1 {
+		return "", nil, fmt.Errorf("%w: eule %s [script]", errUsage, flags.Name())
+	}
+	path := flags.Arg(0)
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", flags.Name(), err)
+	}
+	return path, source, nil
+}
+
+func runDisasm(args []string) error {
+	flags := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	_, source, err := parseCommand(flags, args)
+	if err != nil {
+		return err
+	}
+	var fn *eule.Function
+	if eule.IsBytecode(source) {
+		fn, err = eule.Load(source)
+	} else {
+		fn, err = eule.Compile(source)
+	}
+	if err != nil {
+		return err
+	}
+	return eule.Disassemble(os.Stdout, fn)
+}
+
+func runTokens(args []string) error {
+	flags := flag.NewFlagSet("tokens", flag.ContinueOnError)
+	_, source, err := parseCommand(flags, args)
+	if err != nil {
+		return err
+	}
+	eule.Tokens(os.Stdout, source)
+	return nil
+}
+
+func runCompile(args []string) error {
+	flags := flag.NewFlagSet("compile", flag.ContinueOnError)
+	output := flags.String("o", "", "output `file` (default: script with .eulc extension)")
+	path, source, err := parseCommand(flags, args)
+	if err != nil {
+		return err
+	}
+	fn, err := eule.Compile(source)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + ".eulc"
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("compile: %w", err)
+	}
+	if err := eule.Dump(file, fn); err != nil {
+		file.Close()
+		return fmt.Errorf("compile: %w", err)
+	}
+	return file.Close()
+}
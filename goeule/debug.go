@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"goeule/eule"
+	"io"
+	"maps"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func runDebug(args []string) error {
+	flags := flag.NewFlagSet("debug", flag.ContinueOnError)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	a := &debugAdapter{
+		out:         os.Stdout,
+		requests:    make(chan *debugRequest),
+		stopped:     make(chan eule.StopReason),
+		resume:      make(chan struct{}),
+		done:        make(chan error),
+		breakpoints: map[string][]int{},
+	}
+	go a.read(textproto.NewReader(bufio.NewReader(os.Stdin)))
+	return a.serve()
+}
+
+// debugAdapter speaks the debug adapter protocol on stdio for a single
+// script, run by a debugger on its own goroutine. The serve loop owns the
+// adapter state; while the script is paused it also inspects the VM, the
+// script goroutine waiting on resume until then.
+type debugAdapter struct {
+	mu  sync.Mutex // guards out and seq, also written by script output
+	out io.Writer
+	seq int
+
+	requests chan *debugRequest
+	stopped  chan eule.StopReason
+	resume   chan struct{}
+	done     chan error
+
+	program     string
+	fn          *eule.Function
+	vm          *eule.VM
+	debugger    *eule.Debugger
+	breakpoints map[string][]int // by absolute source path
+
+	entry      bool // the next stop is the requested stop on entry
+	configured bool
+	running    bool
+	paused     bool
+	handles    []any // variables references while paused
+}
+
+type debugRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type debugResponse struct {
+	Seq        int    `json:"seq"`
+	Type       string `json:"type"`
+	RequestSeq int    `json:"request_seq"`
+	Success    bool   `json:"success"`
+	Command    string `json:"command"`
+	Message    string `json:"message,omitempty"`
+	Body       any    `json:"body,omitempty"`
+}
+
+type debugEvent struct {
+	Seq   int    `json:"seq"`
+	Type  string `json:"type"`
+	Event string `json:"event"`
+	Body  any    `json:"body,omitempty"`
+}
+
+type debugSource struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// debugScope is the variables reference of the locals or upvalues of a
+// frame.
+type debugScope struct {
+	frame    int
+	upvalues bool
+}
+
+var errDebugNotPaused = errors.New("the script is not paused")
+
+func (a *debugAdapter) read(in *textproto.Reader) {
+	defer close(a.requests)
+	for {
+		body, err := readMessage(in)
+		if err != nil {
+			return
+		}
+		var req debugRequest
+		if err := json.Unmarshal(body, &req); err == nil {
+			a.requests <- &req
+		}
+	}
+}
+
+func (a *debugAdapter) serve() error {
+	for {
+		select {
+		case req, ok := <-a.requests:
+			if !ok {
+				return nil
+			}
+			body, err := a.handle(req)
+			a.respond(req, body, err)
+			switch req.Command {
+			case "initialize":
+				a.event("initialized", nil)
+			case "disconnect":
+				return nil
+			}
+		case reason := <-a.stopped:
+			a.paused, a.handles = true, nil
+			description := reason.String()
+			if a.entry {
+				a.entry, description = false, "entry"
+			}
+			a.event("stopped", map[string]any{
+				"reason":            description,
+				"threadId":          1,
+				"allThreadsStopped": true,
+			})
+		case err := <-a.done:
+			a.running = false
+			a.event("exited", map[string]any{"exitCode": eule.ExitCode(err)})
+			a.event("terminated", nil)
+		}
+	}
+}
+
+func (a *debugAdapter) handle(req *debugRequest) (any, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string]any{
+			"supportsConfigurationDoneRequest": true,
+			"supportsTerminateRequest":         true,
+		}, nil
+	case "launch":
+		var args struct {
+			Program     string   `json:"program"`
+			Args        []string `json:"args"`
+			StopOnEntry bool     `json:"stopOnEntry"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if err := a.launch(args.Program, args.Args); err != nil {
+			return nil, err
+		}
+		if args.StopOnEntry {
+			a.entry = true
+			a.debugger.Pause()
+		}
+		if a.configured {
+			a.start()
+		}
+		return nil, nil
+	case "setBreakpoints":
+		var args struct {
+			Source      debugSource `json:"source"`
+			Breakpoints []struct {
+				Line int `json:"line"`
+			} `json:"breakpoints"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		path, _ := filepath.Abs(args.Source.Path)
+		var lines []int
+		var breakpoints []map[string]any
+		for _, b := range args.Breakpoints {
+			lines = append(lines, b.Line)
+			verified := a.debugger == nil || path != a.program || a.debugger.Breakable(b.Line)
+			breakpoints = append(breakpoints, map[string]any{"line": b.Line, "verified": verified})
+		}
+		a.breakpoints[path] = lines
+		if a.debugger != nil && path == a.program {
+			a.debugger.SetBreakpoints(lines)
+		}
+		return map[string]any{"breakpoints": breakpoints}, nil
+	case "configurationDone":
+		a.configured = true
+		if a.debugger != nil {
+			a.start()
+		}
+		return nil, nil
+	case "threads":
+		return map[string]any{
+			"threads": []map[string]any{{"id": 1, "name": "main"}},
+		}, nil
+
+	case "stackTrace":
+		if !a.paused {
+			return nil, errDebugNotPaused
+		}
+		frames := []map[string]any{}
+		for i, f := range a.debugger.Frames() {
+			frame := map[string]any{"id": i, "name": f.Function, "line": f.Line, "column": 1}
+			if f.Internal {
+				frame["presentationHint"] = "subtle"
+			} else {
+				frame["source"] = debugSource{filepath.Base(a.program), a.program}
+			}
+			frames = append(frames, frame)
+		}
+		return map[string]any{"stackFrames": frames, "totalFrames": len(frames)}, nil
+	case "scopes":
+		var args struct {
+			FrameID int `json:"frameId"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if !a.paused {
+			return nil, errDebugNotPaused
+		}
+		if args.FrameID < 0 || args.FrameID >= len(a.debugger.Frames()) {
+			return nil, fmt.Errorf("unknown frame %d", args.FrameID)
+		}
+		scopes := []map[string]any{{
+			"name":               "Locals",
+			"presentationHint":   "locals",
+			"variablesReference": a.reference(debugScope{args.FrameID, false}),
+		}}
+		if len(a.debugger.Upvalues(args.FrameID)) != 0 {
+			scopes = append(scopes, map[string]any{
+				"name":               "Upvalues",
+				"variablesReference": a.reference(debugScope{args.FrameID, true}),
+			})
+		}
+		scopes = append(scopes, map[string]any{
+			"name":               "Globals",
+			"variablesReference": a.reference(a.vm.Global),
+			"expensive":          true,
+		})
+		return map[string]any{"scopes": scopes}, nil
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+		if !a.paused {
+			return nil, errDebugNotPaused
+		}
+		if args.VariablesReference < 1 || args.VariablesReference > len(a.handles) {
+			return nil, fmt.Errorf("unknown variables reference %d", args.VariablesReference)
+		}
+		return map[string]any{"variables": a.variables(a.handles[args.VariablesReference-1])}, nil
+
+	case "continue", "next", "stepIn", "stepOut":
+		if !a.paused {
+			return nil, errDebugNotPaused
+		}
+		switch req.Command {
+		case "continue":
+			a.debugger.Continue()
+		case "next":
+			a.debugger.StepOver()
+		case "stepIn":
+			a.debugger.StepIn()
+		case "stepOut":
+			a.debugger.StepOut()
+		}
+		a.paused = false
+		a.resume <- struct{}{}
+		if req.Command == "continue" {
+			return map[string]any{"allThreadsContinued": true}, nil
+		}
+		return nil, nil
+	case "pause":
+		if a.debugger != nil {
+			a.debugger.Pause()
+		}
+		return nil, nil
+	case "terminate", "disconnect":
+		if a.running {
+			a.vm.Interrupt()
+			if a.paused {
+				a.debugger.Continue()
+				a.paused = false
+				a.resume <- struct{}{}
+			}
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported request %s", req.Command)
+}
+
+func (a *debugAdapter) launch(program string, args []string) error {
+	if a.debugger != nil {
+		return errors.New("a script is already launched")
+	}
+	path, err := filepath.Abs(program)
+	if err != nil {
+		return err
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	a.vm = eule.New(
+		eule.WithFS(eule.HostFS()),
+		eule.WithGlobalLibs(),
+		eule.WithArgs(args...),
+		eule.WithEnv(environ()),
+		eule.WithModules(eule.FSLoader(os.DirFS(filepath.Dir(path)))),
+		eule.WithStdout(debugOutput{a, "stdout"}),
+		eule.WithStderr(debugOutput{a, "stderr"}),
+	)
+	if eule.IsBytecode(source) {
+		a.fn, err = eule.Load(source)
+	} else {
+		a.fn, err = eule.Compile(source)
+	}
+	if errors.Is(err, eule.ErrInterpretCompileError) {
+		for _, d := range a.vm.Analyze(source).Diagnostics {
+			if d.Rule == "" {
+				a.output("stderr", fmt.Sprintf("%s:%d: %s\n", program, d.Line, d.Message))
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	a.program = path
+	a.debugger = a.vm.Debug(a.fn, a.stop)
+	a.debugger.SetBreakpoints(a.breakpoints[path])
+	return nil
+}
+
+func (a *debugAdapter) start() {
+	if a.running {
+		return
+	}
+	a.running = true
+	go func() { a.done <- a.debugger.Run() }()
+}
+
+// stop is called by the debugger on the script goroutine.
+func (a *debugAdapter) stop(reason eule.StopReason) {
+	a.stopped <- reason
+	<-a.resume
+}
+
+func (a *debugAdapter) reference(v any) int {
+	a.handles = append(a.handles, v)
+	return len(a.handles)
+}
+
+func (a *debugAdapter) variables(v any) []map[string]any {
+	result := []map[string]any{}
+	add := func(name string, value eule.Value) {
+		ref := 0
+		if t, ok := value.(*eule.Table); ok {
+			ref = a.reference(t)
+		}
+		result = append(result, map[string]any{
+			"name":               name,
+			"value":              debugValue(value),
+			"variablesReference": ref,
+		})
+	}
+	switch v := v.(type) {
+	case debugScope:
+		variables := a.debugger.Locals(v.frame)
+		if v.upvalues {
+			variables = a.debugger.Upvalues(v.frame)
+		}
+		for _, variable := range variables {
+			add(variable.Name, variable.Value)
+		}
+	case *eule.Table:
+		keys := slices.Collect(maps.Keys(v.Pairs))
+		slices.SortFunc(keys, func(a, b eule.String) int {
+			// array indexes in order, then the other keys
+			i, aErr := strconv.Atoi(string(a))
+			j, bErr := strconv.Atoi(string(b))
+			switch {
+			case aErr == nil && bErr == nil:
+				return i - j
+			case aErr == nil:
+				return -1
+			case bErr == nil:
+				return 1
+			}
+			return strings.Compare(string(a), string(b))
+		})
+		for _, key := range keys {
+			add(string(key), v.Pairs[key])
+		}
+	}
+	return result
+}
+
+func debugValue(v eule.Value) string {
+	if s, ok := v.(eule.String); ok {
+		return strconv.Quote(string(s))
+	}
+	return fmt.Sprint(v)
+}
+
+func (a *debugAdapter) respond(req *debugRequest, body any, err error) {
+	res := debugResponse{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Success:    err == nil,
+		Command:    req.Command,
+		Body:       body,
+	}
+	if err != nil {
+		res.Message = err.Error()
+	}
+	a.write(func(seq int) any { res.Seq = seq; return res })
+}
+
+func (a *debugAdapter) event(event string, body any) {
+	a.write(func(seq int) any { return debugEvent{seq, "event", event, body} })
+}
+
+func (a *debugAdapter) output(category, text string) {
+	a.event("output", map[string]any{"category": category, "output": text})
+}
+
+func (a *debugAdapter) write(msg func(seq int) any) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.seq++
+	writeMessage(a.out, msg(a.seq))
+}
+
+// debugOutput forwards what the script prints to the client.
+type debugOutput struct {
+	a        *debugAdapter
+	category string
+}
+
+func (o debugOutput) Write(p []byte) (int, error) {
+	o.a.output(o.category, string(p))
+	return len(p), nil
+}
@@ -0,0 +1,335 @@
+package eule
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Position is a location in source. Line and Column count from 1, Column
+// in bytes.
+type Position struct {
+	Line, Column int
+}
+
+// SymbolKind classifies completions and document symbols.
+type SymbolKind int
+
+const (
+	SymbolVariable SymbolKind = iota
+	SymbolFunction
+	SymbolParameter
+	SymbolField
+	SymbolKeyword
+)
+
+// Symbol is a variable or function declared in a script.
+type Symbol struct {
+	Name       string
+	Kind       SymbolKind
+	Start, End Position
+	Detail     string
+}
+
+// Completion is a name that can be typed at a position.
+type Completion struct {
+	Label  string
+	Kind   SymbolKind
+	Detail string
+}
+
+// Analysis is what the compiler learned about the variables of a script:
+// where each is declared and used, resolved the same way the compiler
+// resolves locals, upvalues and globals. Globals not declared in the
+// script are looked up in the VM that made the analysis.
+type Analysis struct {
+	Diagnostics []Diagnostic // compile errors, or lint findings if none
+
+	vm     *VM
+	source []byte
+	l      *linter
+}
+
+// Analyze compiles source for editor tooling. Unlike Lint it writes
+// nothing and never fails; compile errors end up in Diagnostics.
+func (vm *VM) Analyze(source []byte) *Analysis {
+	c, l := newLintCompiler(source, io.Discard)
+	a := &Analysis{vm: vm, source: source, l: l}
+	if c.compile() == nil {
+		a.Diagnostics = c.errors
+		return a
+	}
+	l.finish(vm.Global)
+	a.Diagnostics = l.filter(c.comments)
+	return a
+}
+
+func newLintCompiler(source []byte, errOut io.Writer) (*compiler, *linter) {
+	l := newLinter()
+	c := newCompiler(source)
+	c.errOut = errOut
+	c.lint = l
+	// scan again from the start, keeping the comments for the directives
+	c.scanner = newScanner(source)
+	c.keepComments = true
+	c.advance()
+	c.advance()
+	return c, l
+}
+
+func (r reference) position() Position {
+	return Position{r.line, r.col + 1}
+}
+
+// at returns the reference whose name contains pos or ends right before it.
+func (a *Analysis) at(pos Position) (reference, bool) {
+	for _, r := range a.l.refs {
+		if r.line == pos.Line && r.col+1 <= pos.Column &&
+			pos.Column <= r.col+1+len(r.name) {
+			return r, true
+		}
+	}
+	return reference{}, false
+}
+
+// Definition returns where the variable at pos is declared.
+func (a *Analysis) Definition(pos Position) (Position, bool) {
+	r, ok := a.at(pos)
+	if !ok {
+		return Position{}, false
+	}
+	if r.sym != nil {
+		return Position{r.sym.line, r.sym.col + 1}, true
+	}
+	if g, ok := a.l.globals[r.name]; ok {
+		return Position{g.line, g.col + 1}, true
+	}
+	return Position{}, false
+}
+
+// References returns every occurrence of the variable at pos, its
+// declaration included, in source order.
+func (a *Analysis) References(pos Position) []Position {
+	target, ok := a.at(pos)
+	if !ok {
+		return nil
+	}
+	var refs []Position
+	for _, r := range a.l.refs {
+		if r.sym == target.sym && (r.sym != nil || r.name == target.name) {
+			refs = append(refs, r.position())
+		}
+	}
+	slices.SortFunc(refs, comparePositions)
+	return slices.Compact(refs)
+}
+
+func comparePositions(a, b Position) int {
+	if a.Line != b.Line {
+		return a.Line - b.Line
+	}
+	return a.Column - b.Column
+}
+
+// Hover describes the variable at pos.
+func (a *Analysis) Hover(pos Position) (string, bool) {
+	r, ok := a.at(pos)
+	if !ok {
+		return "", false
+	}
+	if sym := r.sym; sym != nil {
+		kind := "local"
+		switch {
+		case sym.param:
+			kind = "parameter"
+		case r.fn != sym.owner:
+			kind = "upvalue"
+		}
+		var fn *Function
+		if !sym.assigned {
+			fn = sym.fn
+		}
+		return fmt.Sprintf("%s %s, declared on ln %d",
+			kind, a.signature(sym.name, fn), sym.line), true
+	}
+	if g, ok := a.l.globals[r.name]; ok {
+		var fn *Function
+		if g.defined == 1 && !g.assigned {
+			fn = g.fn
+		}
+		return fmt.Sprintf("global %s, declared on ln %d",
+			a.signature(g.name, fn), g.line), true
+	}
+	if v := a.vm.Global.Load(String(r.name)); a.vm.Global.Has(String(r.name)) {
+		return fmt.Sprintf("built-in %s %s", typeOf(v), r.name), true
+	}
+	return fmt.Sprintf("undefined global %s", r.name), true
+}
+
+// signature formats a variable, with its parameters if it is a function.
+func (a *Analysis) signature(name string, fn *Function) string {
+	if fn == nil {
+		return name
+	}
+	params := slices.Clone(a.l.params[fn])
+	if fn.Vararg && len(params) != 0 {
+		params[len(params)-1] = "..." + params[len(params)-1]
+	}
+	return fmt.Sprintf("%s(%s) taking %s",
+		name, strings.Join(params, ", "), fn.arity())
+}
+
+// Symbols returns the globals and named functions declared in source.
+func (a *Analysis) Symbols() []Symbol {
+	var symbols []Symbol
+	add := func(name string, line, col int, fn *Function) {
+		s := Symbol{
+			Name:  name,
+			Kind:  SymbolVariable,
+			Start: Position{line, col + 1},
+			End:   Position{line, col + 1 + len(name)},
+		}
+		if fn != nil {
+			s.Kind = SymbolFunction
+			s.Detail = a.signature(name, fn)
+			if end := slices.Max(append([]int{line}, fn.Lines...)); end > line {
+				s.End = Position{end, 1}
+			}
+		}
+		symbols = append(symbols, s)
+	}
+	for _, g := range a.l.globals {
+		add(g.name, g.line, g.col, g.fn)
+	}
+	for _, r := range a.l.refs {
+		if r.sym != nil && r.sym.fn != nil && r.line == r.sym.line && r.col == r.sym.col {
+			add(r.name, r.line, r.col, r.sym.fn)
+		}
+	}
+	slices.SortFunc(symbols, func(a, b Symbol) int {
+		return comparePositions(a.Start, b.Start)
+	})
+	return symbols
+}
+
+// Completions returns the names that fit at pos: the fields of a global
+// table, or the array methods after a variable known to hold an array,
+// after '.', '::' or '->', otherwise variables in scope, globals and
+// keywords.
+func (a *Analysis) Completions(pos Position) []Completion {
+	lines := bytes.Split(a.source, []byte("\n"))
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return nil
+	}
+	line := lines[pos.Line-1]
+	before := string(line[:min(max(pos.Column-1, 0), len(line))])
+	before = strings.TrimRightFunc(before, isNameRune)
+
+	for _, op := range []string{"::", "->", "."} {
+		if rest, ok := strings.CutSuffix(before, op); ok {
+			receiver := rest[len(strings.TrimRightFunc(rest, isNameRune)):]
+			at := Position{pos.Line, len(rest) - len(receiver) + 1}
+			return a.members(receiver, at)
+		}
+	}
+
+	var items []Completion
+	seen := map[string]bool{}
+	add := func(c Completion) {
+		if !seen[c.Label] {
+			seen[c.Label] = true
+			items = append(items, c)
+		}
+	}
+	for _, r := range slices.Backward(a.l.refs) {
+		sym := r.sym
+		if sym == nil || r.line != sym.line || r.col != sym.col ||
+			pos.Line < sym.line || (sym.endLine != 0 && pos.Line > sym.endLine) {
+			continue
+		}
+		kind := SymbolVariable
+		if sym.param {
+			kind = SymbolParameter
+		} else if sym.fn != nil {
+			kind = SymbolFunction
+		}
+		add(Completion{sym.name, kind, a.signature(sym.name, sym.fn)})
+	}
+	for _, name := range sortedKeys(a.l.globals) {
+		g := a.l.globals[name]
+		kind := SymbolVariable
+		if g.fn != nil {
+			kind = SymbolFunction
+		}
+		add(Completion{name, kind, a.signature(name, g.fn)})
+	}
+	for _, c := range tableCompletions(a.vm.Global) {
+		if !strings.HasPrefix(c.Label, "__") {
+			add(c)
+		}
+	}
+	for _, keyword := range sortedKeys(keywords) {
+		add(Completion{keyword, SymbolKeyword, ""})
+	}
+	return items
+}
+
+// members completes the fields of a global table, or the array methods
+// if the variable at pos is known to hold an array.
+func (a *Analysis) members(receiver string, pos Position) []Completion {
+	if r, ok := a.at(pos); ok && r.name == receiver {
+		if sym := r.sym; sym != nil {
+			return a.arrayMembers(sym.array && !sym.assigned)
+		}
+		if g, ok := a.l.globals[r.name]; ok {
+			// stores mark globals assigned only once the source compiles
+			assigned := slices.ContainsFunc(a.l.stores, func(s globalStore) bool {
+				return s.name == r.name
+			})
+			return a.arrayMembers(g.array && g.defined == 1 && !assigned)
+		}
+	}
+	t, ok := a.vm.Global.Load(String(receiver)).(*Table)
+	if !ok || t == a.vm.arrayProto {
+		return nil
+	}
+	if t.Proto == a.vm.arrayProto {
+		return tableCompletions(a.vm.arrayProto)
+	}
+	return tableCompletions(t)
+}
+
+func (a *Analysis) arrayMembers(array bool) []Completion {
+	if !array {
+		return nil
+	}
+	return tableCompletions(a.vm.arrayProto)
+}
+
+func tableCompletions(t *Table) []Completion {
+	var items []Completion
+	for _, key := range sortedKeys(t.Pairs) {
+		kind := SymbolField
+		switch t.Pairs[key].(type) {
+		case Native, *Function, *Closure:
+			kind = SymbolFunction
+		}
+		items = append(items, Completion{string(key), kind, string(typeOf(t.Pairs[key]))})
+	}
+	return items
+}
+
+func sortedKeys[K ~string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func isNameRune(r rune) bool {
+	return r < 128 && (isAlpha(byte(r)) || isNumeric(byte(r), 10))
+}
@@ -0,0 +1,105 @@
+package eule
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const analysisSource = `var total = 0
+var add(a, b) {
+  var sum = a + b
+  var log() => print(sum)
+  log()
+  return sum
+}
+total = add(1, 2)
+math.
+`
+
+func TestAnalysis(t *testing.T) {
+	a := New(WithGlobalLibs()).Analyze([]byte(analysisSource))
+
+	def, ok := a.Definition(Position{4, 22}) // sum in log
+	if want := (Position{3, 7}); !ok || def != want {
+		t.Errorf("definition of sum: got %v, want %v", def, want)
+	}
+	refs := a.References(Position{8, 1}) // total
+	if want := []Position{{1, 5}, {8, 1}}; !slices.Equal(refs, want) {
+		t.Errorf("references of total: got %v, want %v", refs, want)
+	}
+	if _, ok := a.Definition(Position{4, 16}); ok {
+		t.Error("print has a definition")
+	}
+
+	for _, tt := range []struct {
+		pos  Position
+		want string
+	}{
+		{Position{8, 10}, "global add(a, b) taking 2 arguments, declared on ln 2"},
+		{Position{4, 22}, "upvalue sum, declared on ln 3"},
+		{Position{3, 13}, "parameter a, declared on ln 2"},
+		{Position{4, 16}, "built-in function print"},
+	} {
+		if got, _ := a.Hover(tt.pos); got != tt.want {
+			t.Errorf("hover %v: got %q, want %q", tt.pos, got, tt.want)
+		}
+	}
+
+	var labels []string
+	for _, c := range a.Completions(Position{9, 6}) {
+		labels = append(labels, c.Label)
+	}
+	if !slices.Contains(labels, "sqrt") || slices.Contains(labels, "push") {
+		t.Errorf("math completions: %v", labels)
+	}
+	labels = nil
+	for _, c := range a.Completions(Position{5, 3}) {
+		labels = append(labels, c.Label)
+	}
+	for _, want := range []string{"log", "sum", "a", "total", "print", "while"} {
+		if !slices.Contains(labels, want) {
+			t.Errorf("completions in add: no %s in %v", want, labels)
+		}
+	}
+
+	var names []string
+	for _, s := range a.Symbols() {
+		names = append(names, s.Name)
+	}
+	if got := strings.Join(names, " "); got != "total add log" {
+		t.Errorf("symbols: %s", got)
+	}
+}
+
+func TestAnalysisCompileError(t *testing.T) {
+	a := New().Analyze([]byte("var x = (\n"))
+	if len(a.Diagnostics) == 0 || a.Diagnostics[0].Rule != "" {
+		t.Errorf("diagnostics: %v", a.Diagnostics)
+	}
+}
+
+func TestAnalysisArrayCompletions(t *testing.T) {
+	for _, tt := range []struct {
+		source string
+		array  bool
+	}{
+		{"var xs = [1, 2]\nxs.", true},
+		{"var f() {\n  var xs = []\n  xs::", true},
+		{"var xs = [1][0]\nxs.", false},
+		{"var xs = [1] or 2\nxs.", false},
+		{"var xs = [1]\nxs = 2\nxs.", false},
+		{"var n = 1\nn.", false},
+		{"unknown.", false},
+	} {
+		lines := strings.Split(tt.source, "\n")
+		pos := Position{len(lines), len(lines[len(lines)-1]) + 1}
+		var labels []string
+		for _, c := range New().Analyze([]byte(tt.source)).Completions(pos) {
+			labels = append(labels, c.Label)
+		}
+		if slices.Contains(labels, "push") != tt.array {
+			t.Errorf("%q: completions %v", tt.source, labels)
+		}
+	}
+}
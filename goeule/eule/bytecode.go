@@ -1,6 +1,9 @@
 package eule
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+)
 
 const (
 	opPop uint8 = iota
@@ -73,32 +76,70 @@ const (
 	opCall
 	opCallSpread
 	opReturn
+
+	opImport
+	opLoadExport
+	opDefineConst
+	opArrayRest
+	opTableRest
+	opCallNamed
+	opPopTry
+	opThrow
 )
 
-func printBytecode(f *Function) {
-	printFunctionCode(f)
+// Disassemble writes the bytecode of a script function and of every
+// function nested in its constants to w.
+func Disassemble(w io.Writer, v Value) error {
+	switch v := v.(type) {
+	case *Function:
+		printBytecode(w, v)
+	case *Closure:
+		printBytecode(w, v.fn)
+	default:
+		return fmt.Errorf("cannot disassemble %s", typeOf(v))
+	}
+	return nil
+}
+
+func printBytecode(w io.Writer, f *Function) {
+	printFunctionCode(w, f)
 	for _, c := range f.Constants {
 		if f, ok := c.(*Function); ok {
-			printBytecode(f)
+			printBytecode(w, f)
 		}
 	}
 }
 
-func printFunctionCode(f *Function) {
-	fmt.Println(coverString(f.Name, 24, '='))
+func printFunctionCode(w io.Writer, f *Function) {
+	fmt.Fprintln(w, coverString(f.Name, 24, '='))
+	fmt.Fprintf(w, "params %d", f.ParamCount)
+	if f.Vararg {
+		fmt.Fprint(w, " + vararg")
+	}
+	fmt.Fprintf(w, ", constants %d, upvalues %d\n", len(f.Constants), len(f.Upvals))
+	for i, upval := range f.Upvals {
+		kind := "upvalue"
+		if upval.IsLocal {
+			kind = "local"
+		}
+		fmt.Fprintf(w, "  upvalue %d <- %s %d %s\n", i, kind, upval.Index, upval.Name)
+	}
+	for _, local := range f.Locals {
+		fmt.Fprintf(w, "  local %d %s %04d-%04d\n", local.Slot, local.Name, local.Start, local.End)
+	}
 
 	for offset := 0; offset < len(f.Code); {
-		offset = printInstruction(f, offset)
-		fmt.Println()
+		offset = printInstruction(w, f, offset)
+		fmt.Fprintln(w)
 	}
 }
 
-func printInstruction(f *Function, offset int) int {
-	fmt.Printf("%04d", offset)
+func printInstruction(w io.Writer, f *Function, offset int) int {
+	fmt.Fprintf(w, "%04d", offset)
 	if offset > 0 && f.Lines[offset] == f.Lines[offset-1] {
-		fmt.Printf("   | ")
+		fmt.Fprintf(w, "   | ")
 	} else {
-		fmt.Printf("%4d ", f.Lines[offset])
+		fmt.Fprintf(w, "%4d ", f.Lines[offset])
 	}
 
 	switch op := f.Code[offset]; op {
@@ -107,29 +148,30 @@ func printInstruction(f *Function, offset int) int {
 		opTypeOf, opReturn, opStoreTemp, opLoadTemp, opAddTableKey, opStoreKey,
 		opLoadKey, opCloseUpvalue, opClosure, opMod,
 		opOr, opXor, opAnd, opRev, opAddTableSpread, opAddArrayElement,
-		opAddArraySpread, opArray, opCloseTry, opToString:
-		return simpleInstruction(f, offset)
+		opAddArraySpread, opArray, opCloseTry, opToString, opPopTry, opThrow:
+		return simpleInstruction(w, f, offset)
 	case opConstant, opDefineGlobal, opStoreGlobal,
-		opLoadGlobal:
-		return constantInstruction(f, offset)
+		opLoadGlobal, opImport, opLoadExport, opDefineConst:
+		return constantInstruction(w, f, offset)
 	case opSmallInteger, opCall, opStoreLocal, opLoadLocal, opLoadUpvalue,
-		opStoreUpvalue, opCallSpread:
-		return byteInstruction(f, offset)
+		opStoreUpvalue, opCallSpread, opArrayRest, opTableRest, opCallNamed:
+		return byteInstruction(w, f, offset)
 	case opJump, opJumpIfFalse, opJumpIfDone, opJumpBack, opOpenTry:
 		sign := 1
 		if op == opJumpBack {
 			sign = -1
 		}
-		return jumpInstruction(f, offset, sign)
+		return jumpInstruction(w, f, offset, sign)
 	default:
 		panic(unreachable)
 	}
 }
 
-func constantInstruction(f *Function, offset int) int {
+func constantInstruction(w io.Writer, f *Function, offset int) int {
 	name := opNames[f.Code[offset]]
 	index := f.Code[offset+1]
-	fmt.Printf(
+	fmt.Fprintf(
+		w,
 		"%-20s |> %04d %-8v ",
 		name,
 		index,
@@ -138,24 +180,33 @@ func constantInstruction(f *Function, offset int) int {
 	return offset + 2
 }
 
-func simpleInstruction(f *Function, offset int) int {
+func simpleInstruction(w io.Writer, f *Function, offset int) int {
 	name := opNames[f.Code[offset]]
-	fmt.Printf("%-20s |%16c", name, ' ')
+	fmt.Fprintf(w, "%-20s |%16c", name, ' ')
 	return offset + 1
 }
 
-func byteInstruction(f *Function, offset int) int {
-	name := opNames[f.Code[offset]]
+func byteInstruction(w io.Writer, f *Function, offset int) int {
+	op := f.Code[offset]
 	slot := f.Code[offset+1]
-	fmt.Printf("%-20s |> %04d%10c", name, slot, ' ')
+	var variable string
+	switch op {
+	case opLoadLocal, opStoreLocal:
+		variable, _ = f.Local(int(slot), offset)
+	case opLoadUpvalue, opStoreUpvalue:
+		if int(slot) < len(f.Upvals) {
+			variable = f.Upvals[slot].Name
+		}
+	}
+	fmt.Fprintf(w, "%-20s |> %04d %-8s ", opNames[op], slot, shortString(variable, 8))
 	return offset + 2
 }
 
-func jumpInstruction(f *Function, offset int, sign int) int {
+func jumpInstruction(w io.Writer, f *Function, offset int, sign int) int {
 	name := opNames[f.Code[offset]]
 	jump := uint16(f.Code[offset+1]) << 8
 	jump |= uint16(f.Code[offset+2])
-	fmt.Printf("%-20s |> %04d >>> %04d ", name, offset, offset+3+sign*int(jump))
+	fmt.Fprintf(w, "%-20s |> %04d >>> %04d ", name, offset, offset+3+sign*int(jump))
 	return offset + 3
 }
 
@@ -230,4 +281,94 @@ var opNames = [...]string{
 	opCall:       "call",
 	opCallSpread: "call_spread",
 	opReturn:     "return",
+
+	opImport:      "import",
+	opLoadExport:  "load_export",
+	opDefineConst: "define_const",
+	opArrayRest:   "array_rest",
+	opTableRest:   "table_rest",
+	opCallNamed:   "call_named",
+	opPopTry:      "pop_try",
+	opThrow:       "throw",
+}
+
+// instructionSize returns the length in bytes of an instruction with op.
+func instructionSize(op uint8) int {
+	switch op {
+	case opConstant, opDefineGlobal, opStoreGlobal, opLoadGlobal,
+		opSmallInteger, opCall, opStoreLocal, opLoadLocal, opLoadUpvalue,
+		opStoreUpvalue, opCallSpread, opImport, opLoadExport, opDefineConst,
+		opArrayRest, opTableRest, opCallNamed:
+		return 2
+	case opJump, opJumpIfFalse, opJumpIfDone, opJumpBack, opOpenTry:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// singlePush holds the instructions pushing one value without popping any.
+var singlePush = map[uint8]empty{
+	opNihil:        {},
+	opFalse:        {},
+	opTrue:         {},
+	opSmallInteger: {},
+	opConstant:     {},
+	opLoadGlobal:   {},
+	opLoadLocal:    {},
+	opLoadUpvalue:  {},
+}
+
+// describe names the variable or field the value n slots below the top of
+// the stack was loaded from, before the instruction at offset ran, like
+// " (local 'x')". It only knows if every value above was pushed by a
+// single instruction and no jump lands in between; otherwise it returns "".
+func (f *Function) describe(offset, n int) string {
+	var starts []int
+	index := -1
+	targets := map[int]bool{}
+	for i := 0; i < len(f.Code); i += instructionSize(f.Code[i]) {
+		if i == offset {
+			index = len(starts)
+		}
+		starts = append(starts, i)
+		switch op := f.Code[i]; op {
+		case opJump, opJumpIfFalse, opJumpIfDone, opJumpBack, opOpenTry:
+			jump := int(f.Code[i+1])<<8 | int(f.Code[i+2])
+			if op == opJumpBack {
+				jump = -jump
+			}
+			targets[i+3+jump] = true
+		}
+	}
+
+	for range n + 1 {
+		if index <= 0 || targets[starts[index]] {
+			return ""
+		}
+		index--
+		if _, ok := singlePush[f.Code[starts[index]]]; !ok && n != 0 {
+			return ""
+		}
+		n--
+	}
+
+	at := starts[index]
+	switch op := f.Code[at]; op {
+	case opLoadLocal:
+		if name, ok := f.Local(int(f.Code[at+1]), at); ok && name[0] != '@' {
+			return fmt.Sprintf(" (local '%s')", name)
+		}
+	case opLoadUpvalue:
+		return fmt.Sprintf(" (upvalue '%s')", f.Upvals[f.Code[at+1]].Name)
+	case opLoadGlobal:
+		return fmt.Sprintf(" (global '%s')", f.Constants[f.Code[at+1]])
+	case opLoadKey:
+		if index > 0 && !targets[at] && f.Code[starts[index-1]] == opConstant {
+			if key, ok := f.Constants[f.Code[starts[index-1]+1]].(String); ok {
+				return fmt.Sprintf(" (field '%s')", key)
+			}
+		}
+	}
+	return ""
 }
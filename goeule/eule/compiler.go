@@ -2,9 +2,13 @@ package eule
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"os"
+	pathpkg "path"
+	"slices"
 	"strconv"
+	"strings"
 )
 
 type compiler struct {
@@ -16,6 +20,23 @@ type compiler struct {
 	enclosing *compiler
 	scope     int
 	prefix    []bool // limit 8?
+	echo      bool
+	echoNext  bool
+	echoed    bool
+	left      [2]token        // first and last token of the left operand of an infix
+	exports   []string        // globals the script returns in a table
+	consts    map[string]bool // globals declared const, shared by nested functions
+	lint      *linter
+	tries     []*tryBlock // try statements whose body or catch block is compiled
+}
+
+// Compile compiles source into a script function without running it.
+func Compile(source []byte) (*Function, error) {
+	fn := newCompiler(source).compile()
+	if fn == nil {
+		return nil, ErrInterpretCompileError
+	}
+	return fn, nil
 }
 
 func newCompiler(source []byte) *compiler {
@@ -26,6 +47,7 @@ func newCompiler(source []byte) *compiler {
 		loop:        nil,
 		enclosing:   nil,
 		scope:       0,
+		consts:      map[string]bool{},
 	}
 }
 
@@ -37,24 +59,44 @@ func (c *compiler) newFunctionCompiler(t fnType, name string) *compiler {
 		loop:        nil,
 		enclosing:   c,
 		scope:       1,
+		consts:      c.consts,
+		lint:        c.lint,
 	}
 }
 
 func (c *compiler) compile() *Function {
 	for !c.match(tokenEof) {
+		c.echoed = false
+		c.echoNext = c.echo
 		c.declaration()
 	}
 	if c.hadError {
 		return nil
 	}
-	c.emitReturn()
+	if c.strict {
+		c.fn.setStrict()
+	}
+	if c.echoed {
+		c.emit(opNihil, opLoadTemp, opReturn)
+	} else if len(c.exports) != 0 {
+		c.emitExports()
+	} else {
+		c.emitReturn()
+	}
 	return c.fn
 }
 
 func (c *compiler) declaration() {
+	kind := c.current.tokenType
 	switch {
 	case c.match(tokenVariable):
-		c.variableDeclaration()
+		c.variableDeclaration(false)
+	case c.match(tokenConst):
+		c.variableDeclaration(true)
+	case c.match(tokenImport):
+		c.importDeclaration()
+	case c.match(tokenExport):
+		c.exportDeclaration()
 	default:
 		c.statement()
 	}
@@ -62,9 +104,15 @@ func (c *compiler) declaration() {
 	if c.panic {
 		c.synchronize()
 	}
+	if c.lint != nil {
+		c.lint.jumped = mapHas(jumpTokens, kind)
+	}
 }
 
 func (c *compiler) statement() {
+	echo := c.echoNext
+	c.echoNext = false
+
 	switch {
 	case c.match(tokenSemicolon), c.match(tokenNewLine):
 		/* pass */
@@ -92,8 +140,15 @@ func (c *compiler) statement() {
 		c.continueStatement()
 	case c.match(tokenReturn):
 		c.returnStatement()
+	case c.match(tokenThrow):
+		c.throwStatement()
+	case c.check(tokenTry) && c.checkNext(tokenLeftBrace):
+		c.advance()
+		c.tryStatement()
 	case c.check(tokenName) && c.checkNext(tokenColon):
 		c.labelStatement()
+	case echo:
+		c.echoStatement()
 	default:
 		c.expressionStatement()
 	}
@@ -101,24 +156,52 @@ func (c *compiler) statement() {
 
 /* ==  statement ============================================================ */
 
-func (c *compiler) variableDeclaration() {
+// variableDeclaration compiles the declarations following var or const
+// and returns the declared names.
+func (c *compiler) variableDeclaration(isConst bool) []string {
+	var names []string
 	var needSemicolon bool
 	for {
+		if c.match(tokenLeftBrace) || c.match(tokenLeftBracket) {
+			names = append(names, c.patternDeclaration(isConst)...)
+			needSemicolon = true
+			if !c.match(tokenComma) {
+				break
+			}
+			continue
+		}
 		nameIndex := c.declareVariable()
-		name := c.previous.literal
+		nameToken := c.previous
+		name := nameToken.literal
+		names = append(names, name)
+		isFunction, isArray := false, false
 		if c.match(tokenEqual) {
+			start := len(c.fn.Code)
 			c.expression()
+			isArray = c.lintIsArray(start)
 			needSemicolon = true
 		} else if c.check(tokenLeftParen) ||
 			c.check(tokenEqualRightAngle) ||
 			c.check(tokenLeftBrace) {
 			isArrow := c.function(name)
 			needSemicolon = isArrow
+			isFunction = true
 		} else {
+			if isConst {
+				c.errorAt(&nameToken, fmt.Sprintf("constant '%s' needs a value", name))
+			}
 			c.emit(opNihil)
 			needSemicolon = true
 		}
-		c.defineVariable(nameIndex)
+		if isConst {
+			c.defineConstant(nameIndex)
+		} else {
+			c.defineVariable(nameIndex)
+		}
+		c.lintDefine(nameToken, isFunction)
+		if isArray {
+			c.lintArrayVariable(nameToken)
+		}
 		if !c.match(tokenComma) {
 			break
 		}
@@ -126,11 +209,120 @@ func (c *compiler) variableDeclaration() {
 	if needSemicolon {
 		c.consumeSemicolon()
 	}
+	return names
+}
+
+// patternDeclaration compiles a declaration destructuring its value, the
+// opening bracket of the pattern just consumed, and returns the declared
+// names.
+func (c *compiler) patternDeclaration(isConst bool) []string {
+	pattern := c.capture()
+	c.consume(tokenEqual)
+	c.expression()
+	d := &destructuring{declare: true, isConst: isConst}
+	c.destructureTop(d, pattern)
+	if c.scope == 0 {
+		c.emit(opPop)
+	}
+	return d.names
+}
+
+// importDeclaration compiles
+//
+//	import "path" [as name]
+//	import { export [as name], ... } from "path"
+//
+// The first form binds the table of exports of the module, by default to
+// the name of its file. Each binding imports the module again, which only
+// runs it the first time.
+func (c *compiler) importDeclaration() {
+	if !c.match(tokenLeftBrace) {
+		c.consume(tokenString)
+		path := c.previous.literal[1 : len(c.previous.literal)-1]
+		pathIndex := c.makeConstant(String(path))
+		name := strings.TrimSuffix(pathpkg.Base(path), moduleExt)
+		if c.matchName("as") {
+			c.consume(tokenName)
+			name = c.previous.literal
+		} else if !isIdentifier(name) {
+			c.errorAtPrevious(fmt.Sprintf("module '%s' needs a name, use import \"%s\" as name", path, path))
+		}
+		nameIndex := c.declareName(name)
+		c.emit(opImport, pathIndex)
+		c.defineConstant(nameIndex)
+		c.lintDefine(token{tokenName, name, c.previous.line, c.previous.col, c.previous.pos}, false)
+		c.consumeSemicolon()
+		return
+	}
+
+	var patches []int
+	for {
+		c.ignoreNewLine()
+		if c.check(tokenRightBrace) {
+			break
+		}
+		c.consume(tokenName)
+		export := c.previous.literal
+		if c.matchName("as") {
+			c.consume(tokenName)
+		}
+		binding := c.previous
+		nameIndex := c.declareName(binding.literal)
+		// the path is patched in once it is known
+		c.emit(opImport, 0)
+		patches = append(patches, len(c.fn.Code)-1)
+		c.emit(opLoadExport, c.makeConstant(String(export)))
+		c.defineConstant(nameIndex)
+		c.lintDefine(binding, false)
+		if !c.match(tokenComma) {
+			break
+		}
+	}
+	c.ignoreNewLine()
+	c.consume(tokenRightBrace)
+	if !c.matchName("from") {
+		c.errorAtCurrent("'from' expected")
+	}
+	c.consume(tokenString)
+	pathIndex := c.makeConstant(String(c.previous.literal[1 : len(c.previous.literal)-1]))
+	for _, offset := range patches {
+		c.fn.Code[offset] = pathIndex
+	}
+	c.consumeSemicolon()
+}
+
+// exportDeclaration compiles export var and export const. The exported
+// globals are returned in a table at the end of the script, with their
+// values at that time.
+func (c *compiler) exportDeclaration() {
+	if c.fnType != fnTypeScript || c.scope != 0 {
+		c.errorAtPrevious("export outside the top level of a script")
+	}
+	isConst := c.match(tokenConst)
+	if !isConst {
+		c.consume(tokenVariable)
+	}
+	for _, name := range c.variableDeclaration(isConst) {
+		if slices.Contains(c.exports, name) {
+			c.errorAtPrevious(fmt.Sprintf("'%s' is already exported", name))
+		}
+		c.exports = append(c.exports, name)
+	}
+}
+
+func (c *compiler) emitExports() {
+	c.emit(opTable)
+	for _, name := range c.exports {
+		nameIndex := c.makeConstant(String(name))
+		c.emit(opConstant, nameIndex, opLoadGlobal, nameIndex, opAddTableKey)
+	}
+	c.emit(opReturn)
 }
 
 func (c *compiler) block() {
 	for !c.check(tokenRightBrace) && !c.check(tokenEof) {
 		c.declaration()
+		c.lintUnreachable()
 	}
 	c.consume(tokenRightBrace)
 }
@@ -226,7 +418,7 @@ func (c *compiler) forStatement(label string) {
 	if c.match(tokenSemicolon) {
 
 	} else if c.match(tokenVariable) {
-		c.variableDeclaration()
+		c.variableDeclaration(false)
 	} else {
 		c.expressionStatement()
 	}
@@ -272,26 +464,47 @@ func (c *compiler) forEachStatement(label string) {
 
 	c.consume(tokenLeftParen)
 	c.addLocal("@iterator")
-	c.defineVariable(c.declareVariable())
+	var pattern []token
+	if c.match(tokenLeftBrace) || c.match(tokenLeftBracket) {
+		pattern = c.capture()
+		c.addLocal("@item")
+		c.initLastLocal()
+	} else {
+		c.defineVariable(c.declareVariable())
+	}
 
 	c.consume(tokenIn)
 	c.expression()
 
 	loopStart := c.beginLoop(label, loopLoop)
+	c.loop.locals--
 
 	c.emit(opDup, opCall, 0)
 	exitJump := c.emitJump(opJumpIfDone)
 	c.consume(tokenRightParen)
 
+	if pattern != nil {
+		c.beginScope()
+		c.replay(pattern)
+		c.advance()
+		slot := uint8(len(c.locals) - 1)
+		c.destructure(&destructuring{declare: true}, func() { c.emit(opLoadLocal, slot) })
+	}
 	c.ignoreNewLine()
 
 	c.statement()
+	if pattern != nil {
+		c.endScope()
+	}
 	c.emit(opPop)
 	c.emitJumpBack(loopStart)
 
+	// break and continue pop the item, and so does the loop when it is
+	// done, so push one for endScope to pop
 	c.patchJump(exitJump)
-
 	c.endLoop()
+	c.emit(opNihil)
+
 	c.endScope()
 }
 
@@ -302,7 +515,8 @@ func (c *compiler) breakStatement() {
 		loop := c.loop
 		for loop != nil {
 			if loop.label == label {
-				loop.addBreak(c.emitJump(opJump))
+				loop.used = true
+				c.jumpOut(exit{tokenBreak, loop})
 				goto end
 			}
 			loop = loop.enclosing
@@ -313,7 +527,7 @@ func (c *compiler) breakStatement() {
 		loop := c.loop
 		for loop != nil {
 			if loop.loopType == loopLoop || loop.loopType == loopSwitch {
-				loop.addBreak(c.emitJump(opJump))
+				c.jumpOut(exit{tokenBreak, loop})
 				goto end
 			}
 			loop = loop.enclosing
@@ -332,11 +546,12 @@ func (c *compiler) continueStatement() {
 		loop := c.loop
 		for loop != nil {
 			if loop.label == label {
+				loop.used = true
 				if loop.loopType != loopLoop {
 					c.errorAtPrevious("continue non loop label")
 					return
 				}
-				c.emitJumpBack(loop.start)
+				c.jumpOut(exit{tokenContinue, loop})
 				goto end
 			}
 			loop = loop.enclosing
@@ -347,7 +562,7 @@ func (c *compiler) continueStatement() {
 		loop := c.loop
 		for loop != nil {
 			if loop.loopType == loopLoop {
-				c.emitJumpBack(loop.start)
+				c.jumpOut(exit{tokenContinue, loop})
 				goto end
 			}
 			loop = loop.enclosing
@@ -365,11 +580,186 @@ func (c *compiler) returnStatement() {
 	}
 
 	if c.matchSemicolon() {
-		c.emitReturn()
+		c.emit(opNihil)
 	} else {
 		c.expressionAllowComma()
 		c.consumeEnd()
+	}
+	c.jumpOut(exit{kind: tokenReturn})
+}
+
+// throwStatement compiles throw expr, which raises the value of expr as
+// it is.
+func (c *compiler) throwStatement() {
+	c.expressionAllowComma()
+	c.consumeEnd()
+	c.emit(opThrow)
+}
+
+// kinds of exit from a try statement to its finally block, see jumpOut
+const (
+	exitEnd   = iota // the end of the body or of the catch block
+	exitThrow        // a value thrown and not caught
+	exitJumps        // the first exit of tryBlock.exits
+)
+
+// tryStatement compiles
+//
+//	try { ... } catch (name) { ... } finally { ... }
+//
+// where the name, the catch clause or the finally clause can be left out.
+// The catch block gets the thrown value in name. The finally block is
+// compiled once: every exit from the body and the catch block jumps to it
+// with its kind in a hidden local, and the value to throw again or to
+// return in another. After the finally block the exit goes on.
+func (c *compiler) tryStatement() {
+	c.consume(tokenLeftBrace)
+	t := &tryBlock{finally: c.finallyFollows()}
+	if t.finally {
+		c.beginScope()
+		c.emit(opNihil)
+		c.addLocal("@value")
+		c.initLastLocal()
+		c.emit(opSmallInteger, exitEnd)
+		c.addLocal("@exit")
+		c.initLastLocal()
+	}
+	t.locals = len(c.locals)
+
+	handlerJump := c.emitJump(opOpenTry)
+	c.tries = append(c.tries, t)
+	c.beginScope()
+	c.block()
+	c.endScope()
+	slicePop(&c.tries)
+	c.emit(opPopTry)
+	ends := []int{c.emitJump(opJump)}
+
+	// the handlers start with the result of try on the stack
+	c.patchJump(handlerJump)
+	caught := c.match(tokenCatch)
+	if caught {
+		c.beginScope()
+		named := c.match(tokenLeftParen)
+		if named {
+			c.emit(opConstant, c.makeConstant(magicValue), opLoadKey)
+			c.defineVariable(c.declareVariable())
+			c.lintDefine(c.previous, false)
+			c.consume(tokenRightParen)
+		} else {
+			c.emit(opPop)
+		}
+		rethrowJump := -1
+		if t.finally {
+			rethrowJump = c.emitJump(opOpenTry)
+			c.tries = append(c.tries, t)
+		}
+		c.consume(tokenLeftBrace)
+		c.beginScope()
+		c.block()
+		c.endScope()
+		c.endScope()
+		if t.finally {
+			slicePop(&c.tries)
+			c.emit(opPopTry)
+			ends = append(ends, c.emitJump(opJump))
+			c.patchJump(rethrowJump)
+			if named {
+				// drop the caught value below the result
+				c.emit(opSwap, opPop)
+			}
+		}
+	}
+	if !t.finally {
+		if !caught {
+			c.errorAtPrevious("'catch' or 'finally' expected")
+		}
+		for _, end := range ends {
+			c.patchJump(end)
+		}
+		return
+	}
+
+	value, kind := uint8(t.locals-2), uint8(t.locals-1)
+	c.emit(opConstant, c.makeConstant(magicValue), opLoadKey, opStoreLocal, value, opPop)
+	c.emit(opSmallInteger, exitThrow, opStoreLocal, kind, opPop)
+	for _, jump := range append(ends, t.jumps...) {
+		c.patchJump(jump)
+	}
+	c.consume(tokenFinally)
+	c.consume(tokenLeftBrace)
+	c.beginScope()
+	c.block()
+	c.endScope()
+
+	c.exitCase(kind, exitThrow, func() { c.emit(opLoadLocal, value, opThrow) })
+	for i, e := range t.exits {
+		c.exitCase(kind, exitJumps+i, func() {
+			if e.kind == tokenReturn {
+				c.emit(opLoadLocal, value)
+			}
+			c.jumpOut(e)
+		})
+	}
+	c.endScope()
+}
+
+// exitCase compiles the code going on with the exit of the given kind
+// after a finally block, which has the kind of its exit in slot.
+func (c *compiler) exitCase(slot uint8, kind int, code func()) {
+	c.emit(opLoadLocal, slot, opSmallInteger, uint8(kind), opEq)
+	next := c.emitJump(opJumpIfFalse)
+	c.emit(opPop)
+	code()
+	c.patchJump(next)
+	c.emit(opPop)
+}
+
+// jumpOut compiles the exit e, leaving the try statements it is in from
+// the innermost: their handlers are unregistered, and the first with a
+// finally block takes the exit over to go on with it after the block. A
+// return has its value on top of the stack.
+func (c *compiler) jumpOut(e exit) {
+	n := 0
+	if e.loop != nil {
+		n = e.loop.tries
+	}
+	for i := len(c.tries) - 1; i >= n; i-- {
+		t := c.tries[i]
+		c.emit(opPopTry)
+		if !t.finally {
+			continue
+		}
+		if e.kind == tokenReturn {
+			c.emit(opStoreLocal, uint8(t.locals-2), opPop)
+		}
+		c.popLocals(t.locals)
+		c.emit(opSmallInteger, t.exitKind(e), opStoreLocal, uint8(t.locals-1), opPop)
+		t.jumps = append(t.jumps, c.emitJump(opJump))
+		return
+	}
+
+	switch e.kind {
+	case tokenReturn:
 		c.emit(opReturn)
+	case tokenBreak:
+		c.popLocals(e.loop.locals)
+		e.loop.addBreak(c.emitJump(opJump))
+	case tokenContinue:
+		c.popLocals(e.loop.locals)
+		c.emitJumpBack(e.loop.start)
+	}
+}
+
+// popLocals compiles popping the locals from the nth on, which stay
+// declared for the code following.
+func (c *compiler) popLocals(n int) {
+	for i := len(c.locals) - 1; i >= n; i-- {
+		if c.locals[i].isCaptured {
+			c.emit(opCloseUpvalue)
+		} else {
+			c.emit(opPop)
+		}
 	}
 }
 
@@ -393,6 +783,8 @@ func (c *compiler) labelStatement() {
 		c.endScope()
 		c.endLoop()
 	default:
+		c.lintReport(c.previous.line, ruleUnusedLabel,
+			"label '%s' of a statement that is not a loop or block", label)
 		c.statement()
 	}
 }
@@ -403,6 +795,15 @@ func (c *compiler) expressionStatement() {
 	c.emit(opPop)
 }
 
+// echoStatement is a top level expression statement whose value is kept in
+// the temp slot so that Eval can return it.
+func (c *compiler) echoStatement() {
+	c.expressionAllowComma()
+	c.consumeEnd()
+	c.emit(opStoreTemp, opPop)
+	c.echoed = true
+}
+
 /* ==  expression =========================================================== */
 
 func (c *compiler) expressionAllowComma() {
@@ -421,10 +822,12 @@ func (c *compiler) precedence(prec precedence) {
 		return
 	}
 
+	start := c.previous
 	canAssign := prec <= precAssign
 	nudFn(canAssign)
 
 	for prec <= precedences[c.current.tokenType] {
+		c.left = [2]token{start, c.previous}
 		c.advance()
 		ledFn := c.led()
 		ledFn(canAssign)
@@ -490,29 +893,37 @@ func (c *compiler) parseVariable(canAssign bool) {
 }
 
 func (c *compiler) namedVariable(name string, canAssign bool) {
-	var getOp, setOp uint8
+	nameToken := c.previous
+	getOp, setOp, index, isConst := c.resolveVariable(name)
+	c.assign(
+		func() { c.emitStore(nameToken, setOp, index, isConst) },
+		func() {
+			c.emit(getOp, uint8(index))
+			c.lintLoad(nameToken)
+		},
+		func() { c.emit(getOp, uint8(index)) },
+		canAssign,
+	)
+}
 
-	var index int
+// resolveVariable returns the instructions loading and storing the variable
+// name, their operand and whether the variable is a constant.
+func (c *compiler) resolveVariable(name string) (getOp, setOp uint8, index int, isConst bool) {
 	if idx, ok := c.resolveLocal(name); ok {
-		index = idx
-		getOp = opLoadLocal
-		setOp = opStoreLocal
+		return opLoadLocal, opStoreLocal, idx, c.locals[idx].isConst
 	} else if idx, ok := c.resolveUpval(name); ok {
-		index = idx
-		getOp = opLoadUpvalue
-		setOp = opStoreUpvalue
-	} else {
-		index = int(c.makeConstant(String(name)))
-		getOp = opLoadGlobal
-		setOp = opStoreGlobal
+		return opLoadUpvalue, opStoreUpvalue, idx, c.isConstUpval(name)
 	}
+	index = int(c.makeConstant(String(name)))
+	return opLoadGlobal, opStoreGlobal, index, c.consts[name]
+}
 
-	c.assign(
-		func() { c.emit(setOp, uint8(index)) },
-		func() { c.emit(getOp, uint8(index)) },
-		func() { c.emit(getOp, uint8(index)) },
-		canAssign,
-	)
+func (c *compiler) emitStore(nameToken token, setOp uint8, index int, isConst bool) {
+	if isConst {
+		c.errorAt(&nameToken, fmt.Sprintf("cannot assign to constant '%s'", nameToken.literal))
+	}
+	c.emit(setOp, uint8(index))
+	c.lintStore(nameToken)
 }
 
 func (c *compiler) resolveLocal(name string) (int, bool) {
@@ -530,13 +941,24 @@ func (c *compiler) resolveUpval(name string) (int, bool) {
 		return 0, false
 	} else if local, ok := c.enclosing.resolveLocal(name); ok {
 		c.enclosing.locals[local].isCaptured = true
-		return c.addUpval(local, true), true
+		return c.addUpval(local, true, name), true
 	} else if upval, ok := c.enclosing.resolveUpval(name); ok {
-		return c.addUpval(upval, false), true
+		return c.addUpval(upval, false, name), true
 	}
 	return 0, false
 }
 
+// isConstUpval reports whether the local an upvalue named name captures is
+// a constant.
+func (c *compiler) isConstUpval(name string) bool {
+	for fc := c.enclosing; fc != nil; fc = fc.enclosing {
+		if i, ok := fc.resolveLocal(name); ok {
+			return fc.locals[i].isConst
+		}
+	}
+	return false
+}
+
 func (c *compiler) parseLiteral(canAssign bool) {
 	switch c.previous.tokenType {
 	case tokenNihil:
@@ -564,6 +986,9 @@ func (c *compiler) parseString(canAssign bool) {
 }
 
 func (c *compiler) parseTable(canAssign bool) {
+	if canAssign && c.patternAssignment() {
+		return
+	}
 	var index float64 = 0
 	c.emit(opTable)
 	if !c.check(tokenRightBrace) {
@@ -609,6 +1034,10 @@ func (c *compiler) parseTable(canAssign bool) {
 }
 
 func (c *compiler) parseArray(canAssign bool) {
+	if canAssign && c.patternAssignment() {
+		return
+	}
+	start := len(c.fn.Code)
 	c.emit(opArray)
 	if !c.check(tokenRightBracket) {
 		for {
@@ -627,6 +1056,7 @@ func (c *compiler) parseArray(canAssign bool) {
 		}
 	}
 	c.consume(tokenRightBracket)
+	c.lintArray(start)
 }
 
 func (c *compiler) parseFunction(canAssign bool) {
@@ -635,6 +1065,7 @@ func (c *compiler) parseFunction(canAssign bool) {
 
 func (c *compiler) function(name string) bool {
 	fc := c.newFunctionCompiler(fnTypeDefault, name)
+	fc.fn.Line = c.previous.line
 
 	if fc.match(tokenLeftParen) {
 		fc.parameterList()
@@ -651,7 +1082,11 @@ func (c *compiler) function(name string) bool {
 		fc.block()
 		fc.emitReturn()
 	}
+	for _, local := range fc.locals {
+		fc.endLocal(local)
+	}
 
+	fc.lintFunction()
 	c.emitConstant(fc.fn)
 	if len(fc.fn.Upvals) != 0 {
 		c.emit(opClosure)
@@ -747,7 +1182,11 @@ func (c *compiler) parseComma(canAssign bool) {
 
 func (c *compiler) parseInfix(canAssign bool) {
 	opType := c.previous.tokenType
+	leftNaN := c.lintIsNaN()
 	c.precedence(precedences[opType] + 1)
+	if opType == tokenEqualEqual || opType == tokenBangEqual {
+		c.lintCompareNaN(leftNaN)
+	}
 	switch opType {
 	case tokenBangEqual:
 		c.emit(opEq, opNot)
@@ -756,7 +1195,7 @@ func (c *compiler) parseInfix(canAssign bool) {
 	case tokenLeftAngle:
 		c.emit(opLt)
 	case tokenLeftAngleEqual:
-		c.emit(opLt)
+		c.emit(opLe)
 	case tokenRightAngle:
 		c.emit(opLe, opNot)
 	case tokenRightAngleEqual:
@@ -807,12 +1246,11 @@ func (c *compiler) parseThen(canAssign bool) {
 }
 
 func (c *compiler) parseCall(canAssign bool) {
-	argCount, spread := c.argumentList()
-	if spread {
-		c.emit(opCallSpread, argCount)
-	} else {
-		c.emit(opCall, argCount)
-	}
+	callee := c.lintCallee()
+	text := c.sourceText(c.left[0], c.left[1])
+	argCount, call := c.argumentList()
+	c.lintCall(callee, argCount, call != opCall)
+	c.emitCall(call, argCount, text)
 }
 
 func (c *compiler) parseKey(canAssign bool) {
@@ -828,9 +1266,13 @@ func (c *compiler) parseKey(canAssign bool) {
 
 func (c *compiler) parseDot(canAssign bool) {
 	c.consumeIdentifierConstant()
+	name := c.previous.literal
 	c.assign(
 		func() { c.emit(opStoreKey) },
-		func() { c.emit(opLoadKey) },
+		func() {
+			c.emit(opLoadKey)
+			c.lintLoadKey(name)
+		},
 		func() { c.emit(opDupTwo, opLoadKey) },
 		canAssign,
 	)
@@ -852,13 +1294,10 @@ func (c *compiler) parseMethodCall(canAssign bool) {
 	c.emit(opDup)
 	c.consumeIdentifierConstant()
 	c.emit(opLoadKey, opSwap)
+	text := c.sourceText(c.left[0], c.previous)
 	c.consume(tokenLeftParen)
-	argCount, spread := c.argumentList()
-	if spread {
-		c.emit(opCallSpread, argCount+1)
-	} else {
-		c.emit(opCall, argCount+1)
-	}
+	argCount, call := c.argumentList()
+	c.emitCall(call, argCount+1, text)
 }
 
 /* == utilities ============================================================= */
@@ -938,10 +1377,14 @@ func (c *compiler) assign(set, get, getNoPop func(), canAssign bool) {
 
 func (c *compiler) declareVariable() uint8 {
 	c.consume(tokenName)
+	return c.declareName(c.previous.literal)
+}
+
+func (c *compiler) declareName(name string) uint8 {
 	if c.scope == 0 {
-		return c.makeConstant(String(c.previous.literal))
+		return c.makeConstant(String(name))
 	} else {
-		c.declareLocalVariable()
+		c.declareLocalVariable(name)
 		return 0
 	}
 }
@@ -954,8 +1397,18 @@ func (c *compiler) defineVariable(nameIndex uint8) {
 	}
 }
 
-func (c *compiler) declareLocalVariable() {
-	name := c.previous.literal
+// defineConstant is defineVariable for a variable that cannot be assigned.
+func (c *compiler) defineConstant(nameIndex uint8) {
+	if c.scope == 0 {
+		c.emit(opDefineConst, nameIndex)
+		c.consts[string(c.fn.Constants[nameIndex].(String))] = true
+	} else {
+		c.initLastLocal()
+		c.locals[len(c.locals)-1].isConst = true
+	}
+}
+
+func (c *compiler) declareLocalVariable(name string) {
 	for i := len(c.locals) - 1; i >= 0; i-- {
 		local := &c.locals[i]
 		if local.depth < c.scope {
@@ -975,10 +1428,12 @@ func (c *compiler) addLocal(name string) {
 			fmt.Sprintf("too many variables (%d)", uint8Max),
 		)
 	}
-	c.locals = append(c.locals, localVar{name, c.scope, false, false})
+	c.locals = append(c.locals, localVar{name, c.scope, false, false, false, len(c.fn.Locals), nil})
+	c.fn.Locals = append(c.fn.Locals, LocalVar{name, len(c.locals) - 1, len(c.fn.Code), 0})
+	c.lintDeclare()
 }
 
-func (c *compiler) addUpval(index int, isLocal bool) int {
+func (c *compiler) addUpval(index int, isLocal bool, name string) int {
 	for i := len(c.fn.Upvals) - 1; i >= 0; i-- {
 		if c.fn.Upvals[i].Index == uint8(index) &&
 			c.fn.Upvals[i].IsLocal == isLocal {
@@ -992,12 +1447,19 @@ func (c *compiler) addUpval(index int, isLocal bool) int {
 		)
 	}
 
-	c.fn.Upvals = append(c.fn.Upvals, compUpval{isLocal, uint8(index)})
+	c.fn.Upvals = append(c.fn.Upvals, compUpval{isLocal, uint8(index), name})
 	return len(c.fn.Upvals) - 1
 }
 
 func (c *compiler) initLastLocal() {
-	c.locals[len(c.locals)-1].isInitialized = true
+	local := &c.locals[len(c.locals)-1]
+	local.isInitialized = true
+	c.fn.Locals[local.debug].Start = len(c.fn.Code)
+}
+
+// endLocal ends the live range of a local going out of scope.
+func (c *compiler) endLocal(local localVar) {
+	c.fn.Locals[local.debug].End = len(c.fn.Code)
 }
 
 func (c *compiler) makeConstant(value Value) uint8 {
@@ -1039,6 +1501,22 @@ func (c *compiler) consumeIdentifierConstant() {
 	c.emitConstant(String(c.previous.literal))
 }
 
+// emitCall emits the call instruction op, recording the source text of the
+// callee for error messages.
+func (c *compiler) emitCall(op, argCount uint8, callee string) {
+	c.emit(op, argCount)
+	if c.fn.Callees == nil {
+		c.fn.Callees = map[int]string{}
+	}
+	c.fn.Callees[len(c.fn.Code)-2] = callee
+}
+
+// sourceText returns the source from the start of token start to the end of
+// token end.
+func (c *compiler) sourceText(start, end token) string {
+	return string(c.source[start.pos : end.pos+len(end.literal)])
+}
+
 func (c *compiler) emitReturn() {
 	c.emit(opNihil, opReturn)
 }
@@ -1080,6 +1558,8 @@ func (c *compiler) endScope() {
 
 	for len(c.locals) > 0 && c.locals[len(c.locals)-1].depth > c.scope {
 		local := c.locals[len(c.locals)-1]
+		c.lintPop(local)
+		c.endLocal(local)
 		if local.isCaptured {
 			c.emit(opCloseUpvalue)
 		} else {
@@ -1090,7 +1570,10 @@ func (c *compiler) endScope() {
 }
 
 func (c *compiler) beginLoop(label string, loopType loopType) int {
-	c.loop = &loop{label, loopType, len(c.fn.Code), nil, c.loop}
+	c.loop = &loop{
+		label, loopType, len(c.fn.Code), nil, c.loop, c.previous.line, false,
+		len(c.locals), len(c.tries),
+	}
 	return len(c.fn.Code)
 }
 
@@ -1098,19 +1581,48 @@ func (c *compiler) endLoop() {
 	for _, breakJump := range c.loop.breaks {
 		c.patchJump(breakJump)
 	}
+	if c.loop.label != "" && !c.loop.used {
+		c.lintReport(c.loop.line, ruleUnusedLabel,
+			"label '%s' is never used", c.loop.label)
+	}
 	c.loop = c.loop.enclosing
 }
 
-func (c *compiler) argumentList() (uint8, bool) {
+// argumentList compiles the arguments of a call and returns the number of
+// positional ones and the call instruction to emit: named arguments
+//
+//	f(a, b: 2, c: 3)
+//
+// follow the positional ones and are passed in a table to opCallNamed.
+func (c *compiler) argumentList() (uint8, uint8) {
 	var argCount uint8 = 0
-	isSpread := false
+	var call uint8 = opCall
+	var named []string
 	if !c.check(tokenRightParen) {
 		for {
-			c.expression()
-			if c.match(tokenDotDotDot) {
-				isSpread = true
+			if c.check(tokenName) && c.checkNext(tokenColon) {
+				if call != opCallNamed {
+					call = opCallNamed
+					c.emit(opTable)
+				}
+				c.consumeIdentifierConstant()
+				if slices.Contains(named, c.previous.literal) {
+					c.errorAtPrevious("duplicate named argument")
+				}
+				named = append(named, c.previous.literal)
+				c.consume(tokenColon)
+				c.expression()
+				c.emit(opAddTableKey)
 			} else {
-				argCount++
+				if call == opCallNamed {
+					c.errorAtCurrent("positional argument after named arguments")
+				}
+				c.expression()
+				if c.match(tokenDotDotDot) {
+					call = opCallSpread
+				} else {
+					argCount++
+				}
 			}
 			if argCount > fnMaxParams {
 				c.errorAtPrevious(
@@ -1120,16 +1632,21 @@ func (c *compiler) argumentList() (uint8, bool) {
 			if !c.match(tokenComma) {
 				break
 			}
-			if c.check(tokenRightParen) || isSpread {
+			if c.check(tokenRightParen) || call == opCallSpread {
 				break
 			}
 		}
 	}
 	c.consume(tokenRightParen)
-	return argCount, isSpread
+	return argCount, call
 }
 
 func (c *compiler) parameterList() {
+	type paramPattern struct {
+		slot   uint8
+		tokens []token
+	}
+	var patterns []paramPattern
 	if !c.check(tokenRightParen) {
 		for {
 			if c.match(tokenDotDotDot) {
@@ -1142,7 +1659,28 @@ func (c *compiler) parameterList() {
 					fmt.Sprintf("too many parameters (%d)", fnMaxParams),
 				)
 			}
-			c.defineVariable(c.declareVariable())
+			if !c.fn.Vararg && (c.match(tokenLeftBrace) || c.match(tokenLeftBracket)) {
+				// the pattern binds locals after all the parameters
+				c.addLocal("@param")
+				c.initLastLocal()
+				patterns = append(patterns, paramPattern{uint8(len(c.locals) - 1), c.capture()})
+				c.fn.Params = append(c.fn.Params, "")
+			} else {
+				c.defineVariable(c.declareVariable())
+				if !c.fn.Vararg {
+					c.fn.Params = append(c.fn.Params, c.previous.literal)
+				}
+			}
+			c.lintParam()
+			if !c.fn.Vararg && c.check(tokenEqual) {
+				// defaults are evaluated by the callee, for void arguments
+				slot := uint8(len(c.locals) - 1)
+				c.emit(opLoadLocal, slot)
+				c.defaultValue()
+				c.emit(opStoreLocal, slot, opPop)
+			} else if !c.fn.Vararg {
+				c.fn.Required = c.fn.ParamCount
+			}
 			if !c.match(tokenComma) {
 				break
 			}
@@ -1152,6 +1690,178 @@ func (c *compiler) parameterList() {
 		}
 	}
 	c.consume(tokenRightParen)
+	for _, p := range patterns {
+		c.replay(p.tokens)
+		c.advance()
+		c.destructure(&destructuring{declare: true}, func() { c.emit(opLoadLocal, p.slot) })
+	}
+}
+
+/* == destructuring ========================================================= */
+
+// destructuring tells how a pattern binds the values it takes apart.
+type destructuring struct {
+	declare bool // declare variables rather than assign existing ones
+	isConst bool
+	names   []string // declared so far
+}
+
+// patternAssignment compiles an assignment destructuring its value if the
+// array or table literal whose opening bracket was just consumed is followed
+// by =, leaving the value as result. Otherwise it compiles nothing and the
+// literal is compiled as usual.
+func (c *compiler) patternAssignment() bool {
+	if !c.closedBeforeEqual() {
+		return false
+	}
+	pattern := c.capture()
+	c.consume(tokenEqual)
+	c.expression()
+	c.destructureTop(&destructuring{}, pattern)
+	return true
+}
+
+// destructureTop takes apart the value on top of the stack with the pattern
+// tokens. The value stays on the stack, as a hidden local when declaring
+// locals.
+func (c *compiler) destructureTop(d *destructuring, pattern []token) {
+	c.replay(pattern)
+	c.advance()
+	if d.declare && c.scope > 0 {
+		c.addLocal("@pattern")
+		c.initLastLocal()
+		slot := uint8(len(c.locals) - 1)
+		c.destructure(d, func() { c.emit(opLoadLocal, slot) })
+	} else {
+		c.destructure(d, func() { c.emit(opDup) })
+	}
+}
+
+// destructure compiles the table or array pattern whose opening bracket was
+// just consumed, taking apart the value load pushes:
+//
+//	{ key [: target] [= default], ..., ...rest }
+//	[ target [= default], ..., ...rest ]
+//
+// A target is a name or a nested pattern. Declared locals take the stack
+// slots the values are pushed to; otherwise binding a value pops it.
+func (c *compiler) destructure(d *destructuring, load func()) {
+	table := c.previous.tokenType == tokenLeftBrace
+	closing := tokenRightBracket
+	if table {
+		closing = tokenRightBrace
+	}
+	var keys []uint8
+	var index float64
+	for {
+		c.ignoreNewLine()
+		if c.check(closing) {
+			break
+		}
+		load()
+		if c.match(tokenDotDotDot) {
+			c.consume(tokenName)
+			if table {
+				for _, key := range keys {
+					c.emit(opConstant, key)
+				}
+				c.emit(opTableRest, uint8(len(keys)))
+			} else {
+				c.emit(opArrayRest, uint8(index))
+			}
+			c.bind(d, c.previous)
+			c.ignoreNewLine()
+			break
+		}
+		if table {
+			c.consume(tokenName)
+			name := c.previous
+			key := c.makeConstant(String(name.literal))
+			keys = append(keys, key)
+			c.emit(opConstant, key, opLoadKey)
+			if !c.match(tokenColon) {
+				c.defaultValue()
+				c.bind(d, name)
+			} else {
+				c.patternTarget(d)
+			}
+			if len(keys) > int(uint8Max) {
+				c.errorAtPrevious(fmt.Sprintf("too many keys (%d)", uint8Max))
+			}
+		} else {
+			c.emitNumber(index)
+			c.emit(opLoadKey)
+			index++
+			c.patternTarget(d)
+			if index > float64(uint8Max) {
+				c.errorAtPrevious(fmt.Sprintf("too many elements (%d)", uint8Max))
+			}
+		}
+		if !c.match(tokenComma) {
+			break
+		}
+	}
+	c.ignoreNewLine()
+	c.consume(closing)
+}
+
+// patternTarget binds the value on top of the stack to the name or nested
+// pattern that follows, defaulted if void.
+func (c *compiler) patternTarget(d *destructuring) {
+	if c.match(tokenName) {
+		name := c.previous
+		c.defaultValue()
+		c.bind(d, name)
+		return
+	}
+	if !c.match(tokenLeftBrace) && !c.match(tokenLeftBracket) {
+		c.errorAtCurrent("variable name or pattern expected")
+		return
+	}
+	pattern := c.capture()
+	c.defaultValue()
+	c.destructureTop(d, pattern)
+	if !d.declare || c.scope == 0 {
+		c.emit(opPop)
+	}
+}
+
+// defaultValue replaces the value on top of the stack by the expression
+// following = if it is void.
+func (c *compiler) defaultValue() {
+	if !c.match(tokenEqual) {
+		return
+	}
+	c.emit(opDup, opNihil, opEq)
+	skip := c.emitJump(opJumpIfFalse)
+	c.emit(opPop, opPop)
+	c.expression()
+	end := c.emitJump(opJump)
+	c.patchJump(skip)
+	c.emit(opPop)
+	c.patchJump(end)
+}
+
+// bind binds the value on top of the stack to the variable name.
+func (c *compiler) bind(d *destructuring, name token) {
+	if !d.declare {
+		_, setOp, index, isConst := c.resolveVariable(name.literal)
+		c.emitStore(name, setOp, index, isConst)
+		c.emit(opPop)
+		return
+	}
+	// declarations report errors and lint at the name
+	previous := c.previous
+	c.previous = name
+	nameIndex := c.declareName(name.literal)
+	if d.isConst {
+		c.defineConstant(nameIndex)
+	} else {
+		c.defineVariable(nameIndex)
+	}
+	c.lintDefine(name, false)
+	c.previous = previous
+	d.names = append(d.names, name.literal)
 }
 
 // == precedence ============================================================ */
@@ -1216,15 +1926,20 @@ var precedences = map[tokenType]precedence{
 
 type tokenReader struct {
 	scanner
+	errOut   io.Writer
+	errors   []Diagnostic
 	next     token
 	current  token
 	previous token
 	hadError bool
 	panic    bool
+	queue    [][]token // read again before scanning on, last first, see replay
+	reads    int       // tokens read or looked ahead at, replays included
+	replayed int       // tokens replayed
 }
 
 func newTokenReader(source []byte) *tokenReader {
-	p := &tokenReader{scanner: newScanner(source)}
+	p := &tokenReader{scanner: newScanner(source), errOut: os.Stderr}
 	p.advance()
 	p.advance()
 	return p
@@ -1235,21 +1950,23 @@ func (r *tokenReader) errorAt(token *token, message string) {
 		return
 	}
 	r.panic = true
-	if !r.hadError {
-		fmt.Fprint(os.Stderr, "compile error: ")
-	} else {
-		fmt.Fprint(os.Stderr, "  also ")
-	}
-	fmt.Fprintf(os.Stderr, "ln %d: %s", token.line, message)
 
 	switch token.tokenType {
 	case tokenEof:
-		fmt.Fprintf(os.Stderr, " at end")
+		message += " at end"
 	case tokenError:
 	default:
-		fmt.Fprintf(os.Stderr, " at '%s'", token.literal)
+		message += fmt.Sprintf(" at '%s'", token.literal)
 	}
-	fmt.Fprintln(os.Stderr)
+	if !r.hadError {
+		fmt.Fprint(r.errOut, "compile error: ")
+	} else {
+		fmt.Fprint(r.errOut, "  also ")
+	}
+	fmt.Fprintf(r.errOut, "ln %d: %s\n", token.line, message)
+	r.errors = append(r.errors, Diagnostic{
+		Line: token.line, Column: token.col + 1, Message: message,
+	})
 
 	r.hadError = true
 }
@@ -1263,8 +1980,19 @@ func (r *tokenReader) errorAtCurrent(message string) {
 }
 
 func (r *tokenReader) advance() {
+	r.reads++
 	r.previous = r.current
 	r.current = r.next
+	for len(r.queue) != 0 {
+		top := &r.queue[len(r.queue)-1]
+		if len(*top) == 0 {
+			r.queue = r.queue[:len(r.queue)-1]
+			continue
+		}
+		r.next = (*top)[0]
+		*top = (*top)[1:]
+		return
+	}
 	for {
 		r.next = r.scanner.scan()
 		if r.next.tokenType != tokenError {
@@ -1274,6 +2002,124 @@ func (r *tokenReader) advance() {
 	}
 }
 
+// capture consumes the tokens up to the bracket closing the one just
+// consumed and returns them, brackets included, for replay. It lets the
+// compiler emit the code of a pattern after the code of what follows it.
+func (r *tokenReader) capture() []token {
+	tokens := []token{r.previous}
+	for depth := 1; depth > 0 && !r.check(tokenEof); r.advance() {
+		switch r.current.tokenType {
+		case tokenLeftParen, tokenLeftBrace, tokenLeftBracket:
+			depth++
+		case tokenRightParen, tokenRightBrace, tokenRightBracket:
+			depth--
+		}
+		tokens = append(tokens, r.current)
+	}
+	return tokens
+}
+
+// replay makes tokens the next to read, followed by the current token.
+// The tokens are not copied, so the same tokens can be replayed again.
+func (r *tokenReader) replay(tokens []token) {
+	r.replayed += len(tokens)
+	rest := []token{r.current, r.next}
+	if len(tokens) == 1 {
+		r.queue = append(r.queue, rest[1:])
+		r.current, r.next = tokens[0], rest[0]
+		return
+	}
+	r.queue = append(r.queue, rest, tokens[2:])
+	r.current, r.next = tokens[0], tokens[1]
+}
+
+// lookAhead calls visit with the tokens from the current one on until it
+// returns false or the source ends. It reads a copy of the scanner, without
+// consuming tokens.
+func (r *tokenReader) lookAhead(visit func(t token) bool) {
+	f := func(t token) bool {
+		r.reads++
+		return visit(t)
+	}
+	if !f(r.current) || !f(r.next) {
+		return
+	}
+	for i := len(r.queue) - 1; i >= 0; i-- {
+		for _, t := range r.queue[i] {
+			if !f(t) {
+				return
+			}
+		}
+	}
+	s := r.scanner
+	for {
+		t := s.scan()
+		if t.tokenType == tokenError {
+			continue
+		}
+		if !f(t) || t.tokenType == tokenEof {
+			return
+		}
+	}
+}
+
+// closedBeforeEqual reports whether the bracket just consumed is closed by
+// a bracket followed by =.
+func (r *tokenReader) closedBeforeEqual() bool {
+	depth, equal := 1, false
+	r.lookAhead(func(t token) bool {
+		if depth == 0 {
+			equal = t.tokenType == tokenEqual
+			return false
+		}
+		switch t.tokenType {
+		case tokenLeftParen, tokenLeftBrace, tokenLeftBracket:
+			depth++
+		case tokenRightParen, tokenRightBrace, tokenRightBracket:
+			depth--
+		}
+		return true
+	})
+	return equal
+}
+
+// finallyFollows reports whether the try statement whose body was just
+// opened has a finally clause, after the body and the catch clause.
+func (r *tokenReader) finallyFollows() bool {
+	depth, clause, finally := 1, tokenTry, false
+	r.lookAhead(func(t token) bool {
+		if depth == 0 {
+			switch {
+			case t.tokenType == tokenFinally:
+				finally = true
+				return false
+			case t.tokenType == tokenCatch && clause == tokenTry:
+				clause = tokenCatch
+				return true
+			case clause == tokenCatch:
+				// the name of the catch clause and its block
+				if t.tokenType != tokenLeftParen && t.tokenType != tokenLeftBrace {
+					return false
+				}
+			default:
+				return false
+			}
+		}
+		switch t.tokenType {
+		case tokenLeftParen, tokenLeftBrace, tokenLeftBracket:
+			depth++
+		case tokenRightParen, tokenRightBracket:
+			depth--
+		case tokenRightBrace:
+			if depth--; depth == 0 && clause == tokenCatch {
+				clause = tokenEof // the catch block is closed
+			}
+		}
+		return true
+	})
+	return finally
+}
+
 func (r *tokenReader) consume(t tokenType) {
 	if r.current.tokenType == t {
 		r.advance()
@@ -1310,6 +2156,15 @@ func (r *tokenReader) consumeEnd() {
 
 func (r *tokenReader) ignoreNewLine() { r.match(tokenNewLine) }
 
+// matchName matches a name that is a keyword only in context, like from.
+func (r *tokenReader) matchName(word string) bool {
+	if !r.check(tokenName) || r.current.literal != word {
+		return false
+	}
+	r.advance()
+	return true
+}
+
 func (r *tokenReader) check(t tokenType) bool {
 	return r.current.tokenType == t
 }
@@ -1345,6 +2200,7 @@ func (r *tokenReader) synchronize() {
 
 var safeTokens = map[tokenType]empty{
 	tokenVariable: {},
+	tokenConst:    {},
 	tokenIf:       {},
 	tokenWhile:    {},
 	tokenDo:       {},
@@ -1352,13 +2208,17 @@ var safeTokens = map[tokenType]empty{
 	tokenBreak:    {},
 	tokenContinue: {},
 	tokenReturn:   {},
+	tokenImport:   {},
+	tokenExport:   {},
+	tokenThrow:    {},
 }
 
 /* == additional ============================================================ */
 
 type compUpval struct {
-	IsLocal bool  `json:"is_local"`
-	Index   uint8 `json:"index"`
+	IsLocal bool   `json:"is_local"`
+	Index   uint8  `json:"index"`
+	Name    string `json:"name"`
 }
 
 type parseFn func(canAssign bool)
@@ -1368,6 +2228,9 @@ type localVar struct {
 	depth         int
 	isInitialized bool
 	isCaptured    bool
+	isConst       bool
+	debug         int     // index in the Locals of the function
+	sym           *symbol // only while linting
 }
 
 type loopType int
@@ -1384,12 +2247,41 @@ type loop struct {
 	start     int
 	breaks    []int
 	enclosing *loop
+	line      int
+	used      bool // label referenced by break or continue
+	locals    int  // locals kept by break and continue
+	tries     int  // try statements entered before the loop
 }
 
 func (l *loop) addBreak(position int) {
 	l.breaks = append(l.breaks, position)
 }
 
+// tryBlock is a try statement during the compilation of its body or of its
+// catch block, which has a handler registered then.
+type tryBlock struct {
+	locals  int    // locals before the body, the hidden ones of finally included
+	finally bool   // the statement has a finally block
+	exits   []exit // exits going on after the finally block
+	jumps   []int  // jumps to the finally block
+}
+
+// exit is a break or continue of loop, or a return if loop is nil.
+type exit struct {
+	kind tokenType
+	loop *loop
+}
+
+// exitKind returns the kind of exit e from t to its finally block.
+func (t *tryBlock) exitKind(e exit) uint8 {
+	i := slices.Index(t.exits, e)
+	if i == -1 {
+		i = len(t.exits)
+		t.exits = append(t.exits, e)
+	}
+	return uint8(exitJumps + i)
+}
+
 type fnType int
 
 const (
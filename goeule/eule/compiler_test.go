@@ -0,0 +1,71 @@
+package eule
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCompileLargeLiteral(t *testing.T) {
+	elems := strings.Repeat("[1, { .a = 2 }, [3]], ", 200)
+	for _, tt := range []struct {
+		source  string
+		pattern bool
+	}{
+		{"var t = [" + strings.Repeat(elems, 4) + "]\n", false},
+		{"[" + elems + "] = t\n", true},
+		// jumps over the try body limit its size
+		{"try {\n  t = [" + elems + "]\n} finally {\n  print(t)\n}\n", false},
+	} {
+		source := tt.source
+		tokens := 0
+		for s := newScanner([]byte(source)); s.scan().tokenType != tokenEof; {
+			tokens++
+		}
+		c := newCompiler([]byte(source))
+		c.errOut = io.Discard
+		c.compile()
+		// the tokens of a literal are looked ahead at once per nesting
+		// level and read once; only patterns are replayed, as replaying
+		// every literal made compiling quadratic
+		if c.reads > 8*tokens {
+			t.Errorf("compiling %d tokens read %d: %.30s", tokens, c.reads, source)
+		}
+		if replayed := c.replayed != 0; replayed != tt.pattern {
+			t.Errorf("replayed %d tokens: %.30s", c.replayed, source)
+		}
+	}
+}
+
+func TestCompileNestedFinally(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("var f(n) {\n")
+	for range 10 {
+		b.WriteString("try {\n  if (n) return n\n} finally {\n")
+	}
+	b.WriteString("print(n)\n")
+	b.WriteString(strings.Repeat("}\n", 10))
+	b.WriteString("}\n")
+	fn, err := Compile([]byte(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every finally block is compiled once, whatever the exits to it
+	for _, v := range fn.Constants {
+		if f, ok := v.(*Function); ok && len(f.Code) > 2000 {
+			t.Errorf("got %d bytes of code", len(f.Code))
+		}
+	}
+}
+
+func TestCompileFinallyErrorOnce(t *testing.T) {
+	source := "var f() {\n  try {\n    return 1\n  } finally {\n    var = 2\n  }\n}\n"
+	c := newCompiler([]byte(source))
+	c.errOut = io.Discard
+	if c.compile() != nil {
+		t.Fatal("compiled")
+	}
+	if len(c.errors) != 1 || c.errors[0].Line != 5 {
+		t.Errorf("got %v, want one error on line 5", c.errors)
+	}
+}
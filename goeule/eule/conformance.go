@@ -0,0 +1,218 @@
+package eule
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTestTimeout bounds a conformance test without a # timeout: comment.
+const DefaultTestTimeout = 2 * time.Second
+
+// TestCase is a script whose expectations are embedded in comments:
+//
+//	print(1) # out: 1
+//	x = 1    # err: runtime error: variable 'x' is undefined
+//	         # exit: 70
+//	         # timeout: 500ms
+//
+// Every # out: line is an expected line of output, with the spaces after
+// the first one kept for indented output. # err: is the expected
+// first line of error output, "..." matching any error. Timeout is zero
+// without a # timeout: comment, and Run then uses DefaultTestTimeout.
+type TestCase struct {
+	Path    string
+	Source  []byte
+	Out     []string
+	Err     string
+	Exit    int
+	Timeout time.Duration
+}
+
+var testDirective = regexp.MustCompile(`# (out|err|exit|timeout):(.*)`)
+
+// ParseTestCase reads the expectations of a conformance test.
+func ParseTestCase(path string, source []byte) (*TestCase, error) {
+	tc := &TestCase{
+		Path:   path,
+		Source: source,
+		Exit:   -1,
+	}
+	for i, line := range strings.Split(string(source), "\n") {
+		match := testDirective.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		value := strings.TrimSpace(match[2])
+		var err error
+		switch match[1] {
+		case "out":
+			// output keeps its leading spaces after the one of the comment
+			out := strings.TrimRight(match[2], " \t\r")
+			tc.Out = append(tc.Out, strings.TrimPrefix(out, " "))
+		case "err":
+			tc.Err = value
+		case "exit":
+			tc.Exit, err = strconv.Atoi(value)
+		case "timeout":
+			tc.Timeout, err = time.ParseDuration(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid %s: %w", path, i+1, match[1], err)
+		}
+	}
+	if tc.Exit == -1 && tc.Err == "" {
+		tc.Exit = 0
+	}
+	return tc, nil
+}
+
+// FindTestCases returns every .eul file under root.
+func FindTestCases(root string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && filepath.Ext(path) == ".eul" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// TestResult is the outcome of running a TestCase.
+type TestResult struct {
+	*TestCase
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	TimedOut bool
+	Elapsed  time.Duration
+	Failure  string
+}
+
+func (r *TestResult) Passed() bool { return r.Failure == "" }
+
+// RunTestFile parses and runs the conformance test at path.
+func RunTestFile(path string, opts ...Option) (*TestResult, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tc, err := ParseTestCase(path, source)
+	if err != nil {
+		return nil, err
+	}
+	return tc.Run(opts...), nil
+}
+
+// Run executes the test in a fresh VM configured with opts and compares
+// its output with the expectations.
+func (tc *TestCase) Run(opts ...Option) *TestResult {
+	var stdout, stderr bytes.Buffer
+	opts = append(opts, WithStdout(&stdout), WithStderr(&stderr))
+	vm := New(opts...)
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- vm.Interpret(tc.Source) }()
+
+	timeout := tc.Timeout
+	if timeout == 0 {
+		timeout = DefaultTestTimeout
+	}
+	r := &TestResult{TestCase: tc}
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		vm.Interrupt()
+		err = <-done
+		r.TimedOut = true
+	}
+	r.Elapsed = time.Since(start)
+	r.Stdout, r.Stderr = stdout.String(), stderr.String()
+	r.ExitCode = ExitCode(err)
+	r.Failure = r.check()
+	return r
+}
+
+func (r *TestResult) check() string {
+	if r.TimedOut {
+		return fmt.Sprintf("timed out after %s", r.Elapsed.Round(time.Millisecond))
+	}
+	if r.Exit != -1 && r.ExitCode != r.Exit {
+		return fmt.Sprintf("expected exit code %d, got %d", r.Exit, r.ExitCode)
+	}
+	if r.Exit == -1 && r.ExitCode == 0 {
+		return fmt.Sprintf("expected error '%s', got none", r.Err)
+	}
+	if r.Err != "" {
+		got, _, _ := strings.Cut(r.Stderr, "\n")
+		if r.Err != "..." && got != r.Err {
+			return fmt.Sprintf("expected error '%s', got '%s'", r.Err, got)
+		}
+	} else if r.Stderr != "" {
+		got, _, _ := strings.Cut(r.Stderr, "\n")
+		return fmt.Sprintf("unexpected error '%s'", got)
+	}
+	got := strings.Split(strings.TrimSuffix(r.Stdout, "\n"), "\n")
+	if r.Stdout == "" {
+		got = nil
+	}
+	if diff := lineDiff(r.Out, got); diff != "" {
+		return "output differs:\n" + diff
+	}
+	return ""
+}
+
+// lineDiff returns a unified style diff of two line lists or an empty string
+// if they are equal.
+func lineDiff(want, got []string) string {
+	// longest common subsequence table
+	lcs := make([][]int, len(want)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(got)+1)
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		for j := len(got) - 1; j >= 0; j-- {
+			if want[i] == got[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var diff strings.Builder
+	changed := false
+	i, j := 0, 0
+	for i < len(want) || j < len(got) {
+		switch {
+		case i < len(want) && j < len(got) && want[i] == got[j]:
+			fmt.Fprintf(&diff, "   %s\n", want[i])
+			i++
+			j++
+		case j < len(got) && (i == len(want) || lcs[i][j+1] >= lcs[i+1][j]):
+			fmt.Fprintf(&diff, "  +%s\n", got[j])
+			j++
+			changed = true
+		default:
+			fmt.Fprintf(&diff, "  -%s\n", want[i])
+			i++
+			changed = true
+		}
+	}
+	if !changed {
+		return ""
+	}
+	return diff.String()
+}
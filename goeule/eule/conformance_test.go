@@ -0,0 +1,97 @@
+package eule
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const languageTests = "../../tests/language"
+
+func TestLanguage(t *testing.T) {
+	paths, err := FindTestCases(languageTests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		name, _ := filepath.Rel(languageTests, path)
+		t.Run(filepath.ToSlash(name), func(t *testing.T) {
+			t.Parallel()
+			r, err := RunTestFile(path, WithFS(HostFS()), WithGlobalLibs(),
+				WithModules(FSLoader(os.DirFS(filepath.Dir(path)))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !r.Passed() {
+				t.Error(r.Failure)
+			}
+		})
+	}
+}
+
+func TestParseTestCase(t *testing.T) {
+	source := `print(" a") # out:  a
+x # err: runtime error: variable 'x' is undefined
+  # exit: 70
+  # timeout: 50ms
+`
+	tc, err := ParseTestCase("case.eul", []byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(tc.Out, []string{" a"}) || tc.Exit != 70 || tc.Timeout != 50*time.Millisecond ||
+		tc.Err != "runtime error: variable 'x' is undefined" {
+		t.Errorf("got %+v", tc)
+	}
+
+	// the runner chooses the timeout of the tests without one
+	tc, err = ParseTestCase("case.eul", []byte("print(1) # out: 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.Timeout != 0 || tc.Exit != 0 {
+		t.Errorf("defaults: got timeout %v, exit %d", tc.Timeout, tc.Exit)
+	}
+	if _, err := ParseTestCase("case.eul", []byte("# timeout: soon\n")); err == nil ||
+		!strings.Contains(err.Error(), "case.eul:1: invalid timeout") {
+		t.Errorf("invalid timeout: got %v", err)
+	}
+}
+
+func TestTestCaseTimeout(t *testing.T) {
+	tc, err := ParseTestCase("loop.eul", []byte("while (true) {}\n# timeout: 50ms\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := tc.Run()
+	if !r.TimedOut || r.Passed() || r.Elapsed > DefaultTestTimeout {
+		t.Errorf("got timed out %v, failure %q after %v", r.TimedOut, r.Failure, r.Elapsed)
+	}
+}
+
+const unitTests = "../../tests/unit"
+
+func TestUnit(t *testing.T) {
+	paths, err := FindTestCases(unitTests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := RunUnitTests(source)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		for _, r := range results {
+			if !r.Passed() {
+				t.Errorf("%s: %s: ln %d: %s", path, r.Name, r.FailureLine, r.Failure)
+			}
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package eule
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+)
+
+// PreludeFile is the name coverage reports give the embedded prelude.
+const PreludeFile = "include.eul"
+
+// Coverage records which lines of the scripts run by one or more VMs
+// executed and how often. It is not safe for concurrent use.
+type Coverage struct {
+	files  map[string]*coverageFile
+	counts map[*Function][]uint64 // executions by instruction offset
+
+	fn   *Function // the function last counts instructions of
+	last []uint64
+}
+
+type coverageFile struct {
+	source []byte
+	fns    []*Function
+}
+
+// FileCoverage is the coverage of one file: how often each line holding
+// reachable code ran.
+type FileCoverage struct {
+	File  string
+	Lines map[int]uint64
+}
+
+// NewCoverage returns an empty coverage to pass to WithCoverage.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		files:  map[string]*coverageFile{},
+		counts: map[*Function][]uint64{},
+	}
+}
+
+// WithCoverage records the lines the VM executes in c. The scripts it
+// runs are reported as file and the prelude as PreludeFile.
+func WithCoverage(c *Coverage, file string) Option {
+	return func(vm *VM) { vm.coverage, vm.coverFile = c, file }
+}
+
+// add registers the functions of a script compiled from source, which may
+// be nil if it is unknown.
+func (c *Coverage) add(file string, source []byte, script *Function) {
+	f := c.files[file]
+	if f == nil {
+		f = &coverageFile{}
+		c.files[file] = f
+	}
+	if source != nil {
+		f.source = source
+	}
+	var add func(fn *Function)
+	add = func(fn *Function) {
+		if _, ok := c.counts[fn]; ok {
+			return
+		}
+		c.counts[fn] = make([]uint64, len(fn.Code))
+		f.fns = append(f.fns, fn)
+		for _, k := range fn.Constants {
+			if fn, ok := k.(*Function); ok {
+				add(fn)
+			}
+		}
+	}
+	add(script)
+}
+
+// step counts the instruction about to run in frame.
+func (c *Coverage) step(frame *callFrame) {
+	if frame.fn != c.fn {
+		c.fn, c.last = frame.fn, c.counts[frame.fn]
+	}
+	if c.last != nil {
+		c.last[frame.cursor]++
+	}
+}
+
+// Files returns the coverage of every file, sorted by name. A line counts
+// as often as its most executed instruction.
+func (c *Coverage) Files() []FileCoverage {
+	var files []FileCoverage
+	for _, name := range slices.Sorted(maps.Keys(c.files)) {
+		f := c.files[name]
+		fc := FileCoverage{File: name, Lines: map[int]uint64{}}
+		// the implicit return of a script sits on the line past its end
+		last := math.MaxInt
+		if f.source != nil {
+			last = bytes.Count(f.source, []byte("\n")) + 1
+			if bytes.HasSuffix(f.source, []byte("\n")) {
+				last--
+			}
+		}
+		for _, fn := range f.fns {
+			counts := c.counts[fn]
+			for offset, ok := range fn.reachable() {
+				line := fn.Lines[offset]
+				if !ok || line > last {
+					continue
+				}
+				fc.Lines[line] = max(fc.Lines[line], counts[offset])
+			}
+		}
+		files = append(files, fc)
+	}
+	return files
+}
+
+// Covered returns the number of lines that ran at least once.
+func (f FileCoverage) Covered() int {
+	n := 0
+	for _, count := range f.Lines {
+		if count != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// reachable reports for every instruction offset of f whether control flow
+// can get there. Offsets inside an instruction are false.
+func (f *Function) reachable() []bool {
+	seen := make([]bool, len(f.Code))
+	work := []int{0}
+	for len(work) != 0 {
+		offset := slicePop(&work)
+		for offset < len(f.Code) && !seen[offset] {
+			seen[offset] = true
+			op := f.Code[offset]
+			next := offset + instructionSize(op)
+			switch op {
+			case opJump, opJumpIfFalse, opJumpIfDone, opJumpBack, opOpenTry:
+				jump := int(f.Code[offset+1])<<8 | int(f.Code[offset+2])
+				if op == opJumpBack {
+					jump = -jump
+				}
+				work = append(work, next+jump)
+			}
+			if op == opReturn || op == opThrow || op == opJump || op == opJumpBack {
+				break
+			}
+			offset = next
+		}
+	}
+	return seen
+}
+
+func percent(covered, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(covered) / float64(total)
+}
+
+// WriteSummary writes the share of lines covered by file and in total.
+func (c *Coverage) WriteSummary(w io.Writer) {
+	files := c.Files()
+	width := len("total")
+	for _, f := range files {
+		width = max(width, len(f.File))
+	}
+	covered, total := 0, 0
+	for _, f := range files {
+		fmt.Fprintf(w, "%-*s %5d/%-5d %5.1f%%\n", width, f.File, f.Covered(), len(f.Lines),
+			percent(f.Covered(), len(f.Lines)))
+		covered += f.Covered()
+		total += len(f.Lines)
+	}
+	fmt.Fprintf(w, "%-*s %5d/%-5d %5.1f%%\n", width, "total", covered, total, percent(covered, total))
+}
+
+// WriteLCOV writes the line coverage in the LCOV tracefile format.
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	var buf bytes.Buffer
+	for _, f := range c.Files() {
+		fmt.Fprintf(&buf, "TN:\nSF:%s\n", f.File)
+		for _, line := range slices.Sorted(maps.Keys(f.Lines)) {
+			fmt.Fprintf(&buf, "DA:%d,%d\n", line, f.Lines[line])
+		}
+		fmt.Fprintf(&buf, "LF:%d\nLH:%d\nend_of_record\n", len(f.Lines), f.Covered())
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+type htmlFile struct {
+	File    string
+	ID      int
+	Percent string
+	Lines   []htmlLine
+}
+
+type htmlLine struct {
+	Number int
+	Class  string
+	Count  string
+	Text   string
+}
+
+var coverageTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>eule coverage</title>
+<style>
+body { font-family: sans-serif; }
+pre { font-size: 13px; line-height: 1.4; }
+.hit { background: #dfd; }
+.miss { background: #fdd; }
+.num, .count { display: inline-block; color: #888; text-align: right; user-select: none; }
+.num { width: 4em; }
+.count { width: 6em; margin-right: 1em; }
+</style>
+</head>
+<body>
+<h1>eule coverage</h1>
+<ul>
+{{- range .}}
+<li><a href="#file{{.ID}}">{{.File}}</a> {{.Percent}}</li>
+{{- end}}
+</ul>
+{{- range .}}
+<h2 id="file{{.ID}}">{{.File}} {{.Percent}}</h2>
+{{- if .Lines}}
+<pre>
+{{- range .Lines}}
+<span class="{{.Class}}"><span class="num">{{.Number}}</span><span class="count">{{.Count}}</span>{{.Text}}</span>
+{{- end}}
+</pre>
+{{- else}}
+<p>source unavailable</p>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes a page listing the source of every file, with covered
+// lines in green and uncovered lines in red.
+func (c *Coverage) WriteHTML(w io.Writer) error {
+	var files []htmlFile
+	for i, f := range c.Files() {
+		hf := htmlFile{
+			File:    f.File,
+			ID:      i,
+			Percent: fmt.Sprintf("%.1f%%", percent(f.Covered(), len(f.Lines))),
+		}
+		source := c.files[f.File].source
+		if source != nil {
+			for i, text := range strings.Split(strings.TrimSuffix(string(source), "\n"), "\n") {
+				line := htmlLine{Number: i + 1, Text: text}
+				if count, ok := f.Lines[i+1]; ok {
+					line.Class, line.Count = "hit", fmt.Sprint(count)
+					if count == 0 {
+						line.Class = "miss"
+					}
+				}
+				hf.Lines = append(hf.Lines, line)
+			}
+		}
+		files = append(files, hf)
+	}
+	return coverageTemplate.Execute(w, files)
+}
@@ -0,0 +1,61 @@
+package eule
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+const coverageSource = `var sign(n) {
+  if (n < 0) {
+    return -1
+  }
+  return 1
+}
+print(sign(2))
+print([1, 2]::map(sign))
+`
+
+func TestCoverage(t *testing.T) {
+	c := NewCoverage()
+	vm := New(WithStdout(io.Discard), WithCoverage(c, "sign.eul"))
+	if err := vm.Interpret([]byte(coverageSource)); err != nil {
+		t.Fatal(err)
+	}
+
+	files := c.Files()
+	if len(files) != 2 || files[0].File != PreludeFile || files[1].File != "sign.eul" {
+		t.Fatalf("files: %+v", files)
+	}
+	prelude, script := files[0], files[1]
+	if count, ok := script.Lines[3]; !ok || count != 0 {
+		t.Errorf("ln 3: ran %d times, executable %v", count, ok)
+	}
+	if script.Lines[5] != 3 || script.Lines[7] != 1 {
+		t.Errorf("lines: %v", script.Lines)
+	}
+	if _, ok := script.Lines[1]; ok {
+		t.Error("ln 1 holds no code but is reported")
+	}
+	if prelude.Covered() == 0 || prelude.Covered() == len(prelude.Lines) {
+		t.Errorf("prelude: %d of %d lines covered", prelude.Covered(), len(prelude.Lines))
+	}
+
+	var lcov strings.Builder
+	if err := c.WriteLCOV(&lcov); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"SF:include.eul\n", "SF:sign.eul\nDA:2,3\nDA:3,0\n", "LF:7\nLH:6\n"} {
+		if !strings.Contains(lcov.String(), s) {
+			t.Errorf("lcov lacks %q:\n%s", s, lcov.String())
+		}
+	}
+
+	var html strings.Builder
+	if err := c.WriteHTML(&html); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(html.String(), `<span class="miss"><span class="num">3</span><span class="count">0</span>    return -1</span>`) {
+		t.Errorf("html does not mark ln 3 as uncovered:\n%s", html.String())
+	}
+}
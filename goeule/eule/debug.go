@@ -0,0 +1,214 @@
+package eule
+
+import (
+	"slices"
+	"sync/atomic"
+)
+
+// StopReason tells why a debugged script paused.
+type StopReason int
+
+const (
+	StopBreakpoint StopReason = iota
+	StopStep
+	StopPause
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopStep:
+		return "step"
+	default:
+		return "pause"
+	}
+}
+
+// Frame is an active call of a paused script.
+type Frame struct {
+	Function string
+	Line     int
+	Internal bool // the call is not part of the script, e.g. the prelude
+}
+
+type stepMode int
+
+const (
+	stepNone stepMode = iota
+	stepIn
+	stepOver
+	stepOut
+)
+
+// Debugger pauses a script at breakpoints and steps through it line by
+// line. Only functions of the script being debugged are stopped in.
+//
+// The VM calls stop on its own goroutine whenever it pauses and resumes
+// once stop returns; the inspection methods and the stepping methods are
+// only meant to be called while it is paused, from stop or from another
+// goroutine stop waits for. SetBreakpoints and Pause may be called at any
+// time.
+type Debugger struct {
+	vm          *VM
+	fn          *Function
+	stop        func(StopReason)
+	script      map[*Function]bool
+	breakpoints atomic.Pointer[map[int]bool]
+	pause       atomic.Bool
+	step        stepMode
+	depth       int
+	lines       [framesMax]struct{ line, cursor int }
+}
+
+// Debug returns a debugger running the script fn on vm, calling stop
+// whenever it pauses.
+func (vm *VM) Debug(fn *Function, stop func(StopReason)) *Debugger {
+	d := &Debugger{vm: vm, fn: fn, stop: stop, script: map[*Function]bool{}}
+	var collect func(fn *Function)
+	collect = func(fn *Function) {
+		d.script[fn] = true
+		for _, c := range fn.Constants {
+			if fn, ok := c.(*Function); ok {
+				collect(fn)
+			}
+		}
+	}
+	collect(fn)
+	d.breakpoints.Store(&map[int]bool{})
+	return d
+}
+
+// Run executes the script like VM.Run, under the debugger. Call Pause
+// before Run to stop on the first line.
+func (d *Debugger) Run() error {
+	d.vm.debugger = d
+	defer func() { d.vm.debugger = nil }()
+	return d.vm.Run(d.fn)
+}
+
+// SetBreakpoints replaces the lines the script stops on.
+func (d *Debugger) SetBreakpoints(lines []int) {
+	breakpoints := map[int]bool{}
+	for _, line := range lines {
+		breakpoints[line] = true
+	}
+	d.breakpoints.Store(&breakpoints)
+}
+
+// Breakable reports whether the script has code on line.
+func (d *Debugger) Breakable(line int) bool {
+	for fn := range d.script {
+		if slices.Contains(fn.Lines, line) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pause stops the script at the next line it executes. It is safe to
+// call from another goroutine.
+func (d *Debugger) Pause() {
+	d.pause.Store(true)
+}
+
+// Continue runs until the next breakpoint.
+func (d *Debugger) Continue() {
+	d.step = stepNone
+}
+
+// StepIn stops at the next line, entering calls.
+func (d *Debugger) StepIn() {
+	d.step, d.depth = stepIn, d.vm.cst
+}
+
+// StepOver stops at the next line of the current function or its callers.
+func (d *Debugger) StepOver() {
+	d.step, d.depth = stepOver, d.vm.cst
+}
+
+// StepOut stops at the next line of a caller of the current function.
+func (d *Debugger) StepOut() {
+	d.step, d.depth = stepOut, d.vm.cst
+}
+
+// Frames returns the active calls, innermost first.
+func (d *Debugger) Frames() []Frame {
+	var frames []Frame
+	for i := d.vm.cst - 1; i >= 0; i-- {
+		frame := &d.vm.callStack[i]
+		cursor := frame.cursor
+		if i != d.vm.cst-1 {
+			cursor-- // callers are past their call instruction
+		}
+		frames = append(frames, Frame{
+			Function: frame.fn.Name,
+			Line:     frame.fn.Lines[max(cursor, 0)],
+			Internal: !d.script[frame.fn],
+		})
+	}
+	return frames
+}
+
+// Variable is a local variable or upvalue of a paused frame.
+type Variable struct {
+	Name  string
+	Value Value
+}
+
+// Locals returns the local variables in scope in the nth frame of Frames,
+// parameters first.
+func (d *Debugger) Locals(n int) []Variable {
+	i := d.vm.cst - 1 - n
+	frame := &d.vm.callStack[i]
+	end, offset := d.vm.st, frame.cursor
+	if i != d.vm.cst-1 {
+		end = d.vm.callStack[i+1].slots - 1 // the callee of the next frame
+		offset--
+	}
+	var locals []Variable
+	for slot := range end - frame.slots {
+		if name, ok := frame.fn.Local(slot, offset); ok && name[0] != '@' {
+			locals = append(locals, Variable{name, d.vm.stack[frame.slots+slot]})
+		}
+	}
+	return locals
+}
+
+// Upvalues returns the variables the nth frame of Frames closes over.
+func (d *Debugger) Upvalues(n int) []Variable {
+	frame := &d.vm.callStack[d.vm.cst-1-n]
+	var upvalues []Variable
+	for i, upval := range frame.upvals {
+		upvalues = append(upvalues, Variable{frame.fn.Upvals[i].Name, upval.Load()})
+	}
+	return upvalues
+}
+
+// check stops before the first instruction of each line when a
+// breakpoint, a step or a pause asks for it.
+func (d *Debugger) check(frame *callFrame) {
+	last := &d.lines[d.vm.cst-1]
+	line := frame.fn.Lines[frame.cursor]
+	newLine := frame.cursor == 0 || line != last.line || frame.cursor < last.cursor
+	last.line, last.cursor = line, frame.cursor
+	// returning from the stepped function stops in the middle of the line
+	returned := d.step != stepNone && d.vm.cst < d.depth
+	if !newLine && !returned || !d.script[frame.fn] {
+		return
+	}
+
+	var reason StopReason
+	switch {
+	case d.pause.Swap(false):
+		reason = StopPause
+	case returned, d.step == stepIn, d.step == stepOver && d.vm.cst <= d.depth:
+		reason = StopStep
+	case (*d.breakpoints.Load())[line]:
+		reason = StopBreakpoint
+	default:
+		return
+	}
+	d.step = stepNone
+	d.stop(reason)
+}
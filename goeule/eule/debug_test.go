@@ -0,0 +1,213 @@
+package eule
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+)
+
+const debugSource = `var add(a, b) {
+  var sum = a + b
+  return sum
+}
+var scale = 10
+var scaled(n) => add(n, 0) * scale
+var x = scaled(1)
+print([1, 2]::map(scaled))
+`
+
+func TestDebugger(t *testing.T) {
+	tests := []struct {
+		name        string
+		breakpoints []int
+		pause       bool
+		steps       []func(d *Debugger)
+		stops       []string
+	}{
+		{
+			name:        "breakpoints",
+			breakpoints: []int{3},
+			stops: []string{
+				"breakpoint ln 3 add scaled @script",
+				"breakpoint ln 3 add scaled map @script",
+				"breakpoint ln 3 add scaled map @script",
+			},
+		},
+		{
+			name:  "step over",
+			pause: true,
+			steps: []func(d *Debugger){(*Debugger).StepOver, (*Debugger).StepOver, (*Debugger).StepOver},
+			stops: []string{"pause ln 4 @script", "step ln 5 @script", "step ln 6 @script", "step ln 7 @script"},
+		},
+		{
+			name:        "step in and out",
+			breakpoints: []int{7},
+			steps: []func(d *Debugger){
+				(*Debugger).StepIn, (*Debugger).StepIn, (*Debugger).StepIn,
+				(*Debugger).StepOut, (*Debugger).StepOut,
+			},
+			stops: []string{
+				"breakpoint ln 7 @script",
+				"step ln 6 scaled @script",
+				"step ln 2 add scaled @script",
+				"step ln 3 add scaled @script",
+				"step ln 6 scaled @script",
+				"step ln 7 @script",
+			},
+		},
+		{
+			name:        "step through the prelude",
+			breakpoints: []int{8},
+			steps: []func(d *Debugger){
+				(*Debugger).StepIn, (*Debugger).StepOut, (*Debugger).StepOver,
+			},
+			stops: []string{
+				"breakpoint ln 8 @script",
+				"step ln 6 scaled map @script",
+				"step ln 8 @script",
+				"step ln 9 @script",
+			},
+		},
+	}
+	fn, err := Compile([]byte(debugSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		vm := New(WithStdout(io.Discard))
+		var stops []string
+		var d *Debugger
+		d = vm.Debug(fn, func(reason StopReason) {
+			stop := fmt.Sprintf("%v ln %d", reason, d.Frames()[0].Line)
+			for _, f := range d.Frames() {
+				stop += " " + f.Function
+			}
+			stops = append(stops, stop)
+			if len(stops) <= len(tt.steps) {
+				tt.steps[len(stops)-1](d)
+			}
+		})
+		d.SetBreakpoints(tt.breakpoints)
+		if tt.pause {
+			d.Pause()
+		}
+		if err := d.Run(); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if !slices.Equal(stops, tt.stops) {
+			t.Errorf("%s: got %q, want %q", tt.name, stops, tt.stops)
+		}
+	}
+}
+
+func TestDebuggerInspect(t *testing.T) {
+	fn, err := Compile([]byte(debugSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := New(WithStdout(io.Discard))
+	var d *Debugger
+	var locals, callerLocals, upvalues []Variable
+	d = vm.Debug(fn, func(reason StopReason) {
+		if locals == nil {
+			locals, callerLocals = d.Locals(0), d.Locals(1)
+		}
+	})
+	d.SetBreakpoints([]int{3})
+	if !d.Breakable(3) || d.Breakable(20) {
+		t.Errorf("breakable lines: 3 is %v, 20 is %v", d.Breakable(3), d.Breakable(20))
+	}
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []Variable{{"a", Number(1)}, {"b", Number(0)}, {"sum", Number(1)}}; !slices.Equal(locals, want) {
+		t.Errorf("locals: got %v, want %v", locals, want)
+	}
+	if want := []Variable{{"n", Number(1)}}; !slices.Equal(callerLocals, want) {
+		t.Errorf("caller locals: got %v, want %v", callerLocals, want)
+	}
+
+	fn, err = Compile([]byte("{\n  var n = 1\n  var f() {\n    return n\n  }\n  f()\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = vm.Debug(fn, func(reason StopReason) { upvalues = d.Upvalues(0) })
+	d.SetBreakpoints([]int{4})
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []Variable{{"n", Number(1)}}; !slices.Equal(upvalues, want) {
+		t.Errorf("upvalues: got %v, want %v", upvalues, want)
+	}
+}
+
+func TestDebugInfo(t *testing.T) {
+	fn, err := Compile([]byte(debugSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Dump(&buf, fn); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := loaded.Constants[slices.IndexFunc(loaded.Constants, func(v Value) bool {
+		f, ok := v.(*Function)
+		return ok && f.Name == "add"
+	})].(*Function)
+
+	var names []string
+	for _, local := range add.Locals {
+		names = append(names, fmt.Sprintf("%s %d", local.Name, local.Slot))
+	}
+	if want := []string{"a 0", "b 1", "sum 2"}; !slices.Equal(names, want) {
+		t.Errorf("locals: got %q, want %q", names, want)
+	}
+	if want := []string{"a", "b"}; !slices.Equal(add.Params, want) || add.Required != 2 {
+		t.Errorf("params: got %q, %d required, want %q", add.Params, add.Required, want)
+	}
+	if name, ok := add.Local(2, 0); ok {
+		t.Errorf("sum is live before its initializer, as %s", name)
+	}
+	if name, _ := add.Local(2, len(add.Code)-1); name != "sum" {
+		t.Errorf("slot 2 at the end is %q, want sum", name)
+	}
+}
+
+func TestRuntimeErrorNames(t *testing.T) {
+	tests := []struct {
+		source, message string
+	}{
+		{"var x = void\nprint(x + 1)\n", "attempt to add void (global 'x') and number"},
+		{"{\n  var n = 1\n  n()\n}\n", "number is not callable (local 'n')"},
+		{"{\n  var n\n  var f() => -n\n  f()\n}\n", "attempt to neg void (upvalue 'n')"},
+		{"var t = {}\nt.a.b = 1\n", "attempt to store key in void (field 'a')"},
+		{"var t = {}\nprint(1 + (t ? t : 2))\n", "attempt to add number and table"},
+	}
+	for _, tt := range tests {
+		var stderr bytes.Buffer
+		err := New(WithStderr(&stderr), WithStdout(io.Discard)).Interpret([]byte(tt.source))
+		var rerr *RuntimeError
+		if !errors.As(err, &rerr) || rerr.Message != tt.message {
+			t.Errorf("%q: got %v, want %s", tt.source, err, tt.message)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	source := []byte("var f(a, b) => a\nprint(f(1))\n")
+	if err := New(WithStdout(io.Discard)).Interpret(source); err != nil {
+		t.Errorf("lenient: %v", err)
+	}
+	err := New(WithStrict(), WithStderr(io.Discard), WithStdout(io.Discard)).Interpret(source)
+	var rerr *RuntimeError
+	if want := "'f' expects 2 arguments, got 1"; !errors.As(err, &rerr) || rerr.Message != want {
+		t.Errorf("strict: got %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,369 @@
+package eule
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+)
+
+// BytecodeSignature starts every file written by Dump.
+const BytecodeSignature = "\x1bEul"
+
+const bytecodeFormat = 3
+
+const (
+	constNihil uint8 = iota
+	constFalse
+	constTrue
+	constNumber
+	constString
+	constFunction
+)
+
+var errMalformedBytecode = errors.New("malformed bytecode")
+
+// IsBytecode reports whether data starts with the bytecode signature.
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(BytecodeSignature))
+}
+
+// Dump writes fn and all nested functions in the binary bytecode format.
+func Dump(w io.Writer, fn *Function) error {
+	d := &dumper{w: bufio.NewWriter(w)}
+	d.w.WriteString(BytecodeSignature)
+	d.w.WriteByte(bytecodeFormat)
+	d.function(fn)
+	if d.err != nil {
+		return fmt.Errorf("dump bytecode: %w", d.err)
+	}
+	return d.w.Flush()
+}
+
+// Load reads a function written by Dump. Truncated or corrupt files are
+// rejected, but the use of the stack by the bytecode is not verified, so
+// only load files from trusted sources.
+func Load(data []byte) (*Function, error) {
+	if !IsBytecode(data) {
+		return nil, fmt.Errorf("load bytecode: %w", errMalformedBytecode)
+	}
+	l := &loader{r: bytes.NewReader(data[len(BytecodeSignature):])}
+	if format := l.byte(); format != bytecodeFormat && l.err == nil {
+		return nil, fmt.Errorf("load bytecode: unsupported format %d", format)
+	}
+	fn := l.function()
+	if l.err != nil {
+		return nil, fmt.Errorf("load bytecode: %w", l.err)
+	}
+	return fn, nil
+}
+
+type dumper struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (d *dumper) function(fn *Function) {
+	d.string(fn.Name)
+	d.uint(uint64(fn.Line))
+	d.uint(uint64(fn.ParamCount))
+	d.uint(uint64(len(fn.Params)))
+	for _, name := range fn.Params {
+		d.string(name)
+	}
+	d.uint(uint64(fn.Required))
+	d.bool(fn.Vararg)
+	d.bool(fn.Strict)
+
+	d.uint(uint64(len(fn.Code)))
+	d.w.Write(fn.Code)
+	for _, line := range fn.Lines {
+		d.uint(uint64(line))
+	}
+
+	d.uint(uint64(len(fn.Upvals)))
+	for _, upval := range fn.Upvals {
+		d.bool(upval.IsLocal)
+		d.w.WriteByte(upval.Index)
+		d.string(upval.Name)
+	}
+
+	d.uint(uint64(len(fn.Locals)))
+	for _, local := range fn.Locals {
+		d.string(local.Name)
+		d.uint(uint64(local.Slot))
+		d.uint(uint64(local.Start))
+		d.uint(uint64(local.End))
+	}
+
+	d.uint(uint64(len(fn.Callees)))
+	for _, offset := range slices.Sorted(maps.Keys(fn.Callees)) {
+		d.uint(uint64(offset))
+		d.string(fn.Callees[offset])
+	}
+
+	d.uint(uint64(len(fn.Constants)))
+	for _, c := range fn.Constants {
+		d.constant(c)
+	}
+}
+
+func (d *dumper) constant(v Value) {
+	switch v := v.(type) {
+	case Nihil:
+		d.w.WriteByte(constNihil)
+	case Boolean:
+		if v {
+			d.w.WriteByte(constTrue)
+		} else {
+			d.w.WriteByte(constFalse)
+		}
+	case Number:
+		d.w.WriteByte(constNumber)
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(float64(v)))
+		d.w.Write(buf[:])
+	case String:
+		d.w.WriteByte(constString)
+		d.string(string(v))
+	case *Function:
+		d.w.WriteByte(constFunction)
+		d.function(v)
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("cannot dump %s constant", typeOf(v))
+		}
+	}
+}
+
+func (d *dumper) uint(u uint64) {
+	d.w.Write(binary.AppendUvarint(nil, u))
+}
+
+func (d *dumper) bool(b bool) {
+	d.w.WriteByte(uint8(boolToInt(b)))
+}
+
+func (d *dumper) string(s string) {
+	d.uint(uint64(len(s)))
+	d.w.WriteString(s)
+}
+
+type loader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (l *loader) function() *Function {
+	fn := NewFunction(l.string())
+	fn.Line = int(l.uint())
+	fn.ParamCount = int(l.uint())
+	for range l.count() {
+		fn.Params = append(fn.Params, l.string())
+	}
+	fn.Required = int(l.uint())
+	fn.Vararg = l.bool()
+	fn.Strict = l.bool()
+
+	fn.Code = l.bytes(int(l.uint()))
+	fn.Lines = make([]int, 0, len(fn.Code))
+	for range fn.Code {
+		fn.Lines = append(fn.Lines, int(l.uint()))
+	}
+
+	for range l.count() {
+		fn.Upvals = append(fn.Upvals, compUpval{l.bool(), l.byte(), l.string()})
+	}
+
+	for range l.count() {
+		fn.Locals = append(fn.Locals, LocalVar{
+			l.string(), int(l.uint()), int(l.uint()), int(l.uint()),
+		})
+	}
+
+	for range l.count() {
+		if fn.Callees == nil {
+			fn.Callees = map[int]string{}
+		}
+		fn.Callees[int(l.uint())] = l.string()
+	}
+
+	for range l.count() {
+		fn.Constants = append(fn.Constants, l.constant())
+	}
+
+	if l.err == nil {
+		l.check(fn)
+	}
+	if l.err != nil {
+		return nil
+	}
+	return fn
+}
+
+// check fails unless the operands of the code of fn are in bounds and its
+// jumps land on instructions, so that running a corrupt function cannot
+// index past its code, constants or upvalues. Functions nested in the
+// constants are checked as they load.
+func (l *loader) check(fn *Function) {
+	if fn.ParamCount > fnMaxParams || fn.Required > fn.ParamCount {
+		l.fail()
+		return
+	}
+	for _, c := range fn.Constants {
+		if nested, ok := c.(*Function); ok {
+			for _, upval := range nested.Upvals {
+				if !upval.IsLocal && int(upval.Index) >= len(fn.Upvals) {
+					l.fail()
+					return
+				}
+			}
+		}
+	}
+
+	last := opPop
+	starts := make([]bool, len(fn.Code))
+	var targets []int
+	for offset := 0; offset < len(fn.Code); {
+		starts[offset] = true
+		op := fn.Code[offset]
+		size := 1
+		switch op {
+		case opConstant, opDefineGlobal, opStoreGlobal,
+			opLoadGlobal, opImport, opLoadExport, opDefineConst:
+			size = 2
+		case opSmallInteger, opCall, opStoreLocal, opLoadLocal, opLoadUpvalue,
+			opStoreUpvalue, opCallSpread, opArrayRest, opTableRest, opCallNamed:
+			size = 2
+		case opJump, opJumpIfFalse, opJumpIfDone, opJumpBack, opOpenTry:
+			size = 3
+		default:
+			if int(op) >= len(opNames) {
+				l.fail()
+				return
+			}
+		}
+		if offset+size > len(fn.Code) {
+			l.fail()
+			return
+		}
+
+		valid := true
+		switch op {
+		case opConstant:
+			valid = int(fn.Code[offset+1]) < len(fn.Constants)
+		case opDefineGlobal, opStoreGlobal, opLoadGlobal, opImport, opLoadExport, opDefineConst:
+			index := int(fn.Code[offset+1])
+			if valid = index < len(fn.Constants); valid {
+				_, valid = fn.Constants[index].(String)
+			}
+		case opLoadUpvalue, opStoreUpvalue:
+			valid = int(fn.Code[offset+1]) < len(fn.Upvals)
+		case opJump, opJumpIfFalse, opJumpIfDone, opJumpBack, opOpenTry:
+			jump := int(fn.Code[offset+1])<<8 | int(fn.Code[offset+2])
+			if op == opJumpBack {
+				jump = -jump
+			}
+			target := offset + size + jump
+			valid = target >= 0 && target < len(fn.Code)
+			targets = append(targets, target)
+		}
+		if !valid {
+			l.fail()
+			return
+		}
+		last = op
+		offset += size
+	}
+	if last != opReturn {
+		l.fail()
+	}
+	for _, target := range targets {
+		if !starts[target] {
+			l.fail()
+		}
+	}
+}
+
+func (l *loader) constant() Value {
+	switch tag := l.byte(); tag {
+	case constNihil:
+		return Nihil{}
+	case constFalse:
+		return Boolean(false)
+	case constTrue:
+		return Boolean(true)
+	case constNumber:
+		buf := l.bytes(8)
+		if l.err != nil {
+			return Nihil{}
+		}
+		return Number(math.Float64frombits(binary.LittleEndian.Uint64(buf)))
+	case constString:
+		return String(l.string())
+	case constFunction:
+		if fn := l.function(); fn != nil {
+			return fn
+		}
+		return Nihil{}
+	default:
+		l.fail()
+		return Nihil{}
+	}
+}
+
+func (l *loader) fail() {
+	if l.err == nil {
+		l.err = errMalformedBytecode
+	}
+}
+
+func (l *loader) byte() uint8 {
+	b, err := l.r.ReadByte()
+	if err != nil {
+		l.fail()
+	}
+	return b
+}
+
+func (l *loader) bool() bool {
+	return intToBool(int(l.byte()))
+}
+
+func (l *loader) uint() uint64 {
+	u, err := binary.ReadUvarint(l.r)
+	if err != nil {
+		l.fail()
+	}
+	return u
+}
+
+// count reads a length prefix, bounded by the remaining input so that a
+// corrupt length cannot cause a huge allocation.
+func (l *loader) count() int {
+	n := l.uint()
+	if n > uint64(l.r.Len()) {
+		l.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (l *loader) bytes(n int) []byte {
+	if n < 0 || n > l.r.Len() {
+		l.fail()
+		return nil
+	}
+	buf := make([]byte, n)
+	io.ReadFull(l.r, buf)
+	return buf
+}
+
+func (l *loader) string() string {
+	return string(l.bytes(l.count()))
+}
@@ -0,0 +1,128 @@
+package eule
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestDumpLoad(t *testing.T) {
+	source := `var greet(name, greeting = "hello") => greeting + ", " + name
+var counter() {
+  var n = 0
+  return func => ++n
+}
+var next = counter()
+next()
+try {
+  error({ .code = next() })
+} catch (e) {
+  print(greet("eule"), e.code, 1.5, true, void)
+}
+`
+	fn, err := Compile([]byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dumped bytes.Buffer
+	if err := Dump(&dumped, fn); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(dumped.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again bytes.Buffer
+	if err := Dump(&again, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dumped.Bytes(), again.Bytes()) {
+		t.Error("dumping the loaded function gives other bytecode")
+	}
+
+	var want, got bytes.Buffer
+	if err := New(WithStdout(&want)).Interpret([]byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	if err := New(WithStdout(&got)).Run(loaded); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != want.String() {
+		t.Errorf("got %q, want %q", got.String(), want.String())
+	}
+}
+
+func TestLoadLanguageTests(t *testing.T) {
+	paths, err := FindTestCases(languageTests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := newCompiler(source)
+		c.errOut = io.Discard
+		fn := c.compile()
+		if fn == nil {
+			continue
+		}
+		var dumped bytes.Buffer
+		if err := Dump(&dumped, fn); err != nil {
+			t.Errorf("%s: %v", path, err)
+		} else if _, err := Load(dumped.Bytes()); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+func TestLoadCorrupt(t *testing.T) {
+	fn, err := Compile([]byte("var f(a) => func => a\nfor (var i = 0; i < 3; i++) print(f(i)())\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dumped bytes.Buffer
+	if err := Dump(&dumped, fn); err != nil {
+		t.Fatal(err)
+	}
+	data := dumped.Bytes()
+	for n := range len(data) {
+		if _, err := Load(data[:n]); err == nil {
+			t.Errorf("loading %d of %d bytes succeeds", n, len(data))
+		}
+	}
+	// changed bytes must not make Load panic
+	for i := len(BytecodeSignature) + 1; i < len(data); i++ {
+		for _, b := range []byte{0, 1, 0x7f, 0xff} {
+			corrupt := bytes.Clone(data)
+			corrupt[i] = b
+			Load(corrupt)
+		}
+	}
+
+	for name, code := range map[string][]byte{
+		"constant":    {opConstant, 1, opReturn},
+		"global":      {opLoadGlobal, 0, opReturn},
+		"upvalue":     {opLoadUpvalue, 0, opReturn},
+		"jump":        {opJump, 0, 1, opReturn},
+		"jump back":   {opJumpBack, 0, 4, opReturn},
+		"operand":     {opConstant},
+		"opcode":      {0xff, opReturn},
+		"no return":   {opNihil},
+		"mid-operand": {opJump, 0, 1, opConstant, 0, opReturn},
+	} {
+		fn := NewFunction("f")
+		fn.Code = code
+		fn.Lines = make([]int, len(code))
+		fn.Constants = []Value{Number(1)}
+		var buf bytes.Buffer
+		if err := Dump(&buf, fn); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(buf.Bytes()); err == nil {
+			t.Errorf("%s: loading a bad operand succeeds", name)
+		}
+	}
+}
@@ -0,0 +1,1518 @@
+package eule
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Format returns source laid out in the canonical style: two space
+// indentation, one statement per line, single spaces around binary
+// operators, no semicolons at the end of statements, and table, array and
+// argument lists either kept on one line or broken one element per line
+// with trailing commas. Comments and single blank lines are preserved.
+//
+// The source must compile; compile errors are reported like Compile does.
+func Format(source []byte) ([]byte, error) {
+	if _, err := Compile(source); err != nil {
+		return nil, err
+	}
+
+	f := newFormatter(source)
+	var p printer
+	shebang := bytes.HasPrefix(source, []byte("#!"))
+	if shebang {
+		line, _, _ := bytes.Cut(source, []byte("\n"))
+		p.write(strings.TrimRight(string(line), " \t\r"))
+		p.newline()
+		f.lastLine = 1
+	}
+
+	program, eof, err := f.program()
+	if err != nil {
+		return nil, err
+	}
+	p.stmts(program, shebang)
+	p.lineBreak()
+	p.blankOK = len(program) != 0 || shebang
+	p.comments(eof)
+	p.lineBreak()
+	return p.buf.Bytes(), nil
+}
+
+/* == concrete syntax tree ================================================== */
+
+// fmtToken is a token with the comments around it. Comments on their own
+// lines or before the token on the same line lead it; comments after it on
+// the same line trail it.
+type fmtToken struct {
+	token
+	lead  []fmtComment
+	trail []comment
+	blank bool // a blank line precedes the token and its comments
+}
+
+type fmtComment struct {
+	comment
+	own    bool // first thing on its line
+	blank  bool
+	joined bool // followed by more on its last line
+}
+
+type (
+	fmtStmt any
+	fmtExpr any
+)
+
+type (
+	emptyStmt struct{ semi *fmtToken }
+	exprStmt  struct {
+		x    fmtExpr
+		semi *fmtToken
+	}
+	varStmt struct {
+		kw    *fmtToken
+		decls []*varDecl
+		semi  *fmtToken
+	}
+	varDecl struct {
+		name    *fmtToken
+		pattern *listExpr // in place of name
+		eq      *fmtToken
+		value   fmtExpr
+		fn      *funcExpr
+		comma   *fmtToken
+	}
+	blockStmt struct {
+		open   *fmtToken
+		body   []fmtStmt
+		close  *fmtToken
+		inline bool
+	}
+	ifStmt struct {
+		kw, open *fmtToken
+		cond     fmtExpr
+		close    *fmtToken
+		then     fmtStmt
+		thenBrk  bool
+		elseKw   *fmtToken
+		els      fmtStmt
+		elseBrk  bool
+	}
+	whileStmt struct {
+		kw, open *fmtToken
+		cond     fmtExpr
+		close    *fmtToken
+		body     fmtStmt
+		brk      bool
+	}
+	doStmt struct {
+		kw            *fmtToken
+		body          fmtStmt
+		brk           bool
+		whileKw, open *fmtToken
+		cond          fmtExpr
+		close, semi   *fmtToken
+	}
+	forStmt struct {
+		kw, open *fmtToken
+		init     fmtStmt
+		cond     fmtExpr
+		semi     *fmtToken
+		post     fmtExpr
+		close    *fmtToken
+		body     fmtStmt
+		brk      bool
+		initSemi *fmtToken
+	}
+	forEachStmt struct {
+		kw, open, name *fmtToken
+		pattern        *listExpr // in place of name
+		in             *fmtToken
+		x              fmtExpr
+		close          *fmtToken
+		body           fmtStmt
+		brk            bool
+	}
+	jumpStmt struct {
+		kw, label *fmtToken
+		value     fmtExpr
+		semi      *fmtToken
+	}
+	labelStmt struct {
+		name, colon *fmtToken
+		body        fmtStmt
+	}
+	tryStmt struct {
+		kw                *fmtToken
+		body              *blockStmt
+		catchKw           *fmtToken
+		open, name, close *fmtToken // the catch name in parentheses
+		handler           *blockStmt
+		finallyKw         *fmtToken
+		finally           *blockStmt
+	}
+	importStmt struct {
+		kw, open    *fmtToken // open is nil without a list of exports
+		names       []*importName
+		close, from *fmtToken
+		path        *fmtToken
+		as, alias   *fmtToken
+		semi        *fmtToken
+	}
+	importName struct{ name, as, alias, comma *fmtToken }
+	exportStmt struct {
+		kw   *fmtToken
+		decl *varStmt
+	}
+)
+
+type (
+	atomExpr  struct{ tok *fmtToken }
+	groupExpr struct {
+		open  *fmtToken
+		x     fmtExpr
+		close *fmtToken
+	}
+	unaryExpr struct {
+		op *fmtToken
+		x  fmtExpr
+	}
+	postfixExpr struct {
+		x  fmtExpr
+		op *fmtToken
+	}
+	binaryExpr struct {
+		x   fmtExpr
+		op  *fmtToken
+		brk bool
+		y   fmtExpr
+	}
+	ternaryExpr struct {
+		cond      fmtExpr
+		q         *fmtToken
+		brk       bool
+		then      fmtExpr
+		colon     *fmtToken
+		colonBrk  bool
+		otherwise fmtExpr
+	}
+	callExpr struct {
+		fn   fmtExpr
+		args *listExpr
+	}
+	methodExpr struct {
+		x        fmtExpr
+		op, name *fmtToken
+		args     *listExpr
+	}
+	indexExpr struct {
+		x     fmtExpr
+		open  *fmtToken
+		key   fmtExpr
+		close *fmtToken
+	}
+	dotExpr struct {
+		x        fmtExpr
+		op, name *fmtToken
+	}
+	formatExpr struct {
+		parts []*fmtToken
+		args  []fmtExpr
+		end   *fmtToken
+	}
+	funcExpr struct {
+		kw, open *fmtToken
+		params   []*param
+		close    *fmtToken
+		arrow    *fmtToken
+		body     fmtExpr
+		block    *blockStmt
+	}
+	param struct {
+		dots, name *fmtToken
+		pattern    *listExpr // in place of name
+		eq         *fmtToken // = default
+		value      fmtExpr
+		comma      *fmtToken
+	}
+
+	// listExpr is a call argument list or an array or table literal.
+	listExpr struct {
+		open  *fmtToken
+		elems []*elem
+		close *fmtToken
+		multi bool
+	}
+	elem struct {
+		dot, name   *fmtToken // .name, .name = value, .name(params) {}
+		colon       *fmtToken // name: target in a pattern, name: argument
+		eq          *fmtToken
+		fn          *funcExpr
+		open, close *fmtToken // [key] = value
+		key         fmtExpr
+		rest        *fmtToken // ...name in a pattern
+		value       fmtExpr
+		spread      *fmtToken
+		comma       *fmtToken
+		brk         bool
+	}
+)
+
+/* == parser ================================================================ */
+
+var errFormat = errors.New("format")
+
+// formatter parses source into the concrete syntax tree mirroring the
+// grammar of the compiler, including where it accepts new lines.
+type formatter struct {
+	scanner
+	prev, cur, next *fmtToken
+	last            *fmtToken // last token that is not a new line
+	lastLine        int
+}
+
+func newFormatter(source []byte) *formatter {
+	f := &formatter{scanner: newScanner(source)}
+	f.keepComments = true
+	return f
+}
+
+func (f *formatter) program() (list []fmtStmt, eof *fmtToken, err error) {
+	defer catch(func(e formatError) {
+		err = fmt.Errorf("%w: ln %d: %s", errFormat, e.line, e.message)
+	})
+	f.advance()
+	f.advance()
+	for !f.check(tokenEof) {
+		list = appendStmt(list, f.declaration())
+	}
+	return list, f.cur, nil
+}
+
+type formatError struct {
+	line    int
+	message string
+}
+
+func (f *formatter) scan() *fmtToken {
+	t := &fmtToken{token: f.scanner.scan()}
+	if t.tokenType == tokenNewLine {
+		return t
+	}
+	if t.tokenType == tokenError {
+		panic(formatError{t.line, t.literal})
+	}
+
+	comments := f.comments
+	f.comments = nil
+	end := f.lastLine
+
+	// comments starting on the line of the last token trail it, unless
+	// something else follows them on the same line
+	trailing := 0
+	for trailing < len(comments) && f.last != nil &&
+		comments[trailing].line == f.last.line {
+		trailing++
+	}
+	if trailing > 0 {
+		next := t.line
+		if trailing < len(comments) {
+			next = comments[trailing].line
+		}
+		if comments[trailing-1].end < next {
+			f.last.trail = append(f.last.trail, comments[:trailing]...)
+			comments = comments[trailing:]
+			end = f.last.trail[len(f.last.trail)-1].end
+		}
+	}
+
+	for i, c := range comments {
+		next := t.line
+		if i+1 < len(comments) {
+			next = comments[i+1].line
+		}
+		t.lead = append(t.lead, fmtComment{
+			c, c.line > end, c.line > end+1, next == c.end,
+		})
+		end = c.end
+	}
+	t.blank = t.line > end+1
+	f.last = t
+	f.lastLine = t.line
+	return t
+}
+
+func (f *formatter) advance() {
+	f.prev = f.cur
+	f.cur = f.next
+	f.next = f.scan()
+}
+
+func (f *formatter) check(t tokenType) bool { return f.cur.tokenType == t }
+
+func (f *formatter) match(t tokenType) *fmtToken {
+	if !f.check(t) {
+		return nil
+	}
+	f.advance()
+	return f.prev
+}
+
+func (f *formatter) consume(t tokenType) *fmtToken {
+	if !f.check(t) {
+		panic(formatError{f.cur.line, fmt.Sprintf("'%s' expected", t)})
+	}
+	f.advance()
+	return f.prev
+}
+
+// broken reports whether the current token starts a later line than the
+// previous one.
+func (f *formatter) broken() bool {
+	return f.cur.line > f.prev.line
+}
+
+func (f *formatter) ignoreNewLine() { f.match(tokenNewLine) }
+
+// consumeSemicolon mirrors tokenReader.consumeSemicolon and returns the
+// semicolon if there was one.
+func (f *formatter) consumeSemicolon() *fmtToken {
+	if f.match(tokenNewLine) != nil || f.check(tokenEof) {
+		return nil
+	}
+	return f.consume(tokenSemicolon)
+}
+
+func (f *formatter) matchSemicolon() (*fmtToken, bool) {
+	if f.match(tokenNewLine) != nil {
+		return nil, true
+	}
+	semi := f.match(tokenSemicolon)
+	return semi, semi != nil
+}
+
+func (f *formatter) consumeEnd() *fmtToken {
+	if f.match(tokenNewLine) != nil {
+		return nil
+	}
+	return f.match(tokenSemicolon)
+}
+
+func (f *formatter) declaration() fmtStmt {
+	if kw := f.matchDeclaration(); kw != nil {
+		return f.variableDeclaration(kw)
+	}
+	if kw := f.match(tokenImport); kw != nil {
+		return f.importDeclaration(kw)
+	}
+	if kw := f.match(tokenExport); kw != nil {
+		decl := f.matchDeclaration()
+		if decl == nil {
+			decl = f.consume(tokenVariable)
+		}
+		return &exportStmt{kw, f.variableDeclaration(decl)}
+	}
+	return f.statement()
+}
+
+// matchDeclaration matches var or const.
+func (f *formatter) matchDeclaration() *fmtToken {
+	if kw := f.match(tokenVariable); kw != nil {
+		return kw
+	}
+	return f.match(tokenConst)
+}
+
+func (f *formatter) importDeclaration(kw *fmtToken) *importStmt {
+	s := &importStmt{kw: kw}
+	if s.open = f.match(tokenLeftBrace); s.open != nil {
+		for {
+			f.ignoreNewLine()
+			if f.check(tokenRightBrace) {
+				break
+			}
+			n := &importName{name: f.consume(tokenName)}
+			n.as, n.alias = f.matchAs()
+			s.names = append(s.names, n)
+			if n.comma = f.match(tokenComma); n.comma == nil {
+				break
+			}
+		}
+		f.ignoreNewLine()
+		s.close = f.consume(tokenRightBrace)
+		if !f.check(tokenName) || f.cur.literal != "from" {
+			panic(formatError{f.cur.line, "'from' expected"})
+		}
+		s.from = f.consume(tokenName)
+		s.path = f.consume(tokenString)
+	} else {
+		s.path = f.consume(tokenString)
+		s.as, s.alias = f.matchAs()
+	}
+	s.semi = f.consumeSemicolon()
+	return s
+}
+
+func (f *formatter) matchAs() (as, alias *fmtToken) {
+	if f.check(tokenName) && f.cur.literal == "as" {
+		return f.consume(tokenName), f.consume(tokenName)
+	}
+	return nil, nil
+}
+
+func (f *formatter) statement() fmtStmt {
+	switch {
+	case f.match(tokenNewLine) != nil:
+		return &emptyStmt{}
+	case f.check(tokenSemicolon):
+		return &emptyStmt{f.consume(tokenSemicolon)}
+	case f.check(tokenLeftBrace):
+		return f.block()
+	case f.check(tokenIf), f.check(tokenUnless):
+		return f.ifStatement()
+	case f.check(tokenWhile), f.check(tokenUntil):
+		s := &whileStmt{kw: f.cur}
+		f.advance()
+		s.open = f.consume(tokenLeftParen)
+		s.cond = f.expression(precComma)
+		s.close = f.consume(tokenRightParen)
+		s.body, s.brk = f.body()
+		return s
+	case f.check(tokenDo):
+		return f.doStatement()
+	case f.check(tokenFor):
+		return f.forStatement()
+	case f.check(tokenForEach):
+		s := &forEachStmt{kw: f.cur}
+		f.advance()
+		s.open = f.consume(tokenLeftParen)
+		if s.pattern = f.pattern(); s.pattern == nil {
+			s.name = f.consume(tokenName)
+		}
+		s.in = f.consume(tokenIn)
+		s.x = f.expression(precAssign)
+		s.close = f.consume(tokenRightParen)
+		s.body, s.brk = f.body()
+		return s
+	case f.check(tokenBreak), f.check(tokenContinue):
+		s := &jumpStmt{kw: f.cur}
+		f.advance()
+		if semi, ok := f.matchSemicolon(); ok {
+			s.semi = semi
+			return s
+		}
+		s.label = f.consume(tokenName)
+		s.semi = f.consumeEnd()
+		return s
+	case f.check(tokenReturn), f.check(tokenThrow):
+		s := &jumpStmt{kw: f.cur}
+		f.advance()
+		if semi, ok := f.matchSemicolon(); ok {
+			s.semi = semi
+			return s
+		}
+		s.value = f.expression(precComma)
+		s.semi = f.consumeEnd()
+		return s
+	case f.check(tokenTry) && f.next.tokenType == tokenLeftBrace:
+		return f.tryStatement()
+	case f.check(tokenName) && f.next.tokenType == tokenColon:
+		s := &labelStmt{name: f.cur, colon: f.next}
+		f.advance()
+		f.advance()
+		s.body = f.statement()
+		return s
+	default:
+		x := f.expression(precComma)
+		return &exprStmt{x, f.consumeEnd()}
+	}
+}
+
+// body parses the statement controlled by if, while and the other loops,
+// reporting whether it started on a new line.
+func (f *formatter) body() (fmtStmt, bool) {
+	brk := f.broken()
+	f.ignoreNewLine()
+	return f.statement(), brk
+}
+
+func (f *formatter) variableDeclaration(kw *fmtToken) *varStmt {
+	s := &varStmt{kw: kw}
+	needSemicolon := false
+	for {
+		d := &varDecl{pattern: f.pattern()}
+		if d.pattern != nil {
+			d.eq = f.consume(tokenEqual)
+			d.value = f.expression(precAssign)
+			needSemicolon = true
+		} else if d.name = f.consume(tokenName); f.check(tokenEqual) {
+			d.eq = f.consume(tokenEqual)
+			d.value = f.expression(precAssign)
+			needSemicolon = true
+		} else if f.check(tokenLeftParen) ||
+			f.check(tokenEqualRightAngle) ||
+			f.check(tokenLeftBrace) {
+			d.fn = f.function(nil)
+			needSemicolon = d.fn.arrow != nil
+		} else {
+			needSemicolon = true
+		}
+		s.decls = append(s.decls, d)
+		if d.comma = f.match(tokenComma); d.comma == nil {
+			break
+		}
+	}
+	if needSemicolon {
+		s.semi = f.consumeSemicolon()
+	}
+	return s
+}
+
+func (f *formatter) block() *blockStmt {
+	b := &blockStmt{open: f.consume(tokenLeftBrace)}
+	for !f.check(tokenRightBrace) && !f.check(tokenEof) {
+		b.body = appendStmt(b.body, f.declaration())
+	}
+	b.close = f.consume(tokenRightBrace)
+	b.inline = b.open.line == b.close.line && len(b.body) == 1 &&
+		len(b.open.trail) == 0 && len(b.close.lead) == 0 &&
+		!needsEnd(b.body[0])
+	return b
+}
+
+// appendStmt appends s to list unless it is an empty statement made of a
+// new line, which has no tokens to print.
+func appendStmt(list []fmtStmt, s fmtStmt) []fmtStmt {
+	if e, ok := s.(*emptyStmt); ok && e.semi == nil {
+		return list
+	}
+	return append(list, s)
+}
+
+// needsEnd reports whether s must be followed by a new line or semicolon,
+// which rules out putting it before a closing brace on the same line.
+func needsEnd(s fmtStmt) bool {
+	switch s := s.(type) {
+	case *jumpStmt:
+		return s.label == nil && s.value == nil
+	case *emptyStmt:
+		return true
+	case *varStmt, *importStmt, *exportStmt:
+		return true
+	}
+	return false
+}
+
+func (f *formatter) ifStatement() *ifStmt {
+	s := &ifStmt{kw: f.cur}
+	f.advance()
+	s.open = f.consume(tokenLeftParen)
+	s.cond = f.expression(precComma)
+	s.close = f.consume(tokenRightParen)
+	s.then, s.thenBrk = f.body()
+	if s.elseKw = f.match(tokenElse); s.elseKw != nil {
+		s.elseBrk = f.broken()
+		s.els = f.statement()
+	}
+	return s
+}
+
+func (f *formatter) tryStatement() *tryStmt {
+	s := &tryStmt{kw: f.consume(tokenTry)}
+	s.body = f.block()
+	if s.catchKw = f.match(tokenCatch); s.catchKw != nil {
+		if s.open = f.match(tokenLeftParen); s.open != nil {
+			s.name = f.consume(tokenName)
+			s.close = f.consume(tokenRightParen)
+		}
+		s.handler = f.block()
+	}
+	if s.finallyKw = f.match(tokenFinally); s.finallyKw != nil {
+		s.finally = f.block()
+	}
+	return s
+}
+
+func (f *formatter) doStatement() *doStmt {
+	s := &doStmt{kw: f.consume(tokenDo)}
+	s.body, s.brk = f.body()
+	if s.whileKw = f.match(tokenUntil); s.whileKw == nil {
+		s.whileKw = f.consume(tokenWhile)
+	}
+	s.open = f.consume(tokenLeftParen)
+	s.cond = f.expression(precComma)
+	s.close = f.consume(tokenRightParen)
+	s.semi = f.consumeSemicolon()
+	return s
+}
+
+func (f *formatter) forStatement() *forStmt {
+	s := &forStmt{kw: f.consume(tokenFor)}
+	s.open = f.consume(tokenLeftParen)
+	if semi := f.match(tokenSemicolon); semi != nil {
+		s.initSemi = semi
+	} else if kw := f.match(tokenVariable); kw != nil {
+		v := f.variableDeclaration(kw)
+		s.init, s.initSemi, v.semi = v, v.semi, nil
+	} else {
+		x := f.expression(precComma)
+		s.init, s.initSemi = &exprStmt{x: x}, f.consumeEnd()
+	}
+
+	if s.semi = f.match(tokenSemicolon); s.semi == nil {
+		s.cond = f.expression(precComma)
+		s.semi = f.consumeSemicolon()
+	}
+	if s.close = f.match(tokenRightParen); s.close == nil {
+		s.post = f.expression(precComma)
+		s.close = f.consume(tokenRightParen)
+	}
+	s.body, s.brk = f.body()
+	return s
+}
+
+func (f *formatter) expression(prec precedence) fmtExpr {
+	f.advance()
+	canAssign := prec <= precAssign
+	x := f.nud(canAssign)
+	for prec <= precedences[f.cur.tokenType] {
+		f.advance()
+		x = f.led(x, canAssign)
+	}
+	return x
+}
+
+func (f *formatter) nud(canAssign bool) fmtExpr {
+	t := f.prev
+	switch t.tokenType {
+	case tokenLeftParen:
+		x := f.expression(precComma)
+		return &groupExpr{t, x, f.consume(tokenRightParen)}
+	case tokenName:
+		return f.assign(&atomExpr{t}, canAssign)
+	case tokenNihil, tokenFalse, tokenTrue, tokenNumber, tokenString:
+		return &atomExpr{t}
+	case tokenLeftBrace:
+		return f.patternAssign(f.table(t), canAssign)
+	case tokenLeftBracket:
+		return f.patternAssign(f.list(t, tokenRightBracket), canAssign)
+	case tokenFunction:
+		return f.function(t)
+	case tokenPlus, tokenMinus, tokenBang, tokenTypeOf,
+		tokenPlusPlus, tokenMinusMinus, tokenNot, tokenTry:
+		return &unaryExpr{t, f.expression(precUn)}
+	case tokenFormat:
+		x := &formatExpr{}
+		for {
+			x.parts = append(x.parts, f.prev)
+			x.args = append(x.args, f.expression(precAssign))
+			if f.match(tokenFormat) == nil {
+				break
+			}
+		}
+		x.end = f.consume(tokenString)
+		return x
+	}
+	panic(formatError{t.line, "expression expected"})
+}
+
+func (f *formatter) led(x fmtExpr, canAssign bool) fmtExpr {
+	op := f.prev
+	switch op.tokenType {
+	case tokenComma:
+		brk := f.broken()
+		return &binaryExpr{x, op, brk, f.expression(precComma)}
+	case tokenPipePipe, tokenOr:
+		brk := f.broken()
+		return &binaryExpr{x, op, brk, f.expression(precOr)}
+	case tokenAmperAmper, tokenAnd:
+		brk := f.broken()
+		return &binaryExpr{x, op, brk, f.expression(precAnd)}
+	case tokenQuestion, tokenThen:
+		t := &ternaryExpr{cond: x, q: op, brk: f.broken()}
+		t.then = f.expression(precComma)
+		if t.colon = f.match(tokenElse); t.colon == nil {
+			t.colon = f.consume(tokenColon)
+		}
+		t.colonBrk = f.broken()
+		t.otherwise = f.expression(precAssign)
+		return t
+	case tokenLeftParen:
+		return &callExpr{x, f.list(op, tokenRightParen)}
+	case tokenColonColon:
+		name := f.consume(tokenName)
+		open := f.consume(tokenLeftParen)
+		return &methodExpr{x, op, name, f.list(open, tokenRightParen)}
+	case tokenLeftBracket:
+		key := f.expression(precComma)
+		index := &indexExpr{x, op, key, f.consume(tokenRightBracket)}
+		return f.assign(index, canAssign)
+	case tokenDot, tokenMinusRightAngle:
+		return f.assign(&dotExpr{x, op, f.consume(tokenName)}, canAssign)
+	}
+	brk := f.broken()
+	return &binaryExpr{x, op, brk, f.expression(precedences[op.tokenType] + 1)}
+}
+
+// assign mirrors compiler.assign for a target that has just been parsed.
+func (f *formatter) assign(x fmtExpr, canAssign bool) fmtExpr {
+	if op := f.match(tokenPlusPlus); op != nil {
+		return &postfixExpr{x, op}
+	}
+	if op := f.match(tokenMinusMinus); op != nil {
+		return &postfixExpr{x, op}
+	}
+	if !canAssign {
+		return x
+	}
+	switch f.cur.tokenType {
+	case tokenEqual, tokenPlusEqual, tokenMinusEqual, tokenStarEqual,
+		tokenSlashEqual, tokenPercentEqual:
+		f.advance()
+		op, brk := f.prev, f.broken()
+		return &binaryExpr{x, op, brk, f.expression(precAssign)}
+	case tokenPipePipeEqual:
+		f.advance()
+		op, brk := f.prev, f.broken()
+		return &binaryExpr{x, op, brk, f.expression(precOr)}
+	case tokenAmperAmperEqual:
+		f.advance()
+		op, brk := f.prev, f.broken()
+		return &binaryExpr{x, op, brk, f.expression(precAnd)}
+	}
+	return x
+}
+
+// patternAssign parses an assignment destructuring its value if the literal
+// l is followed by =.
+func (f *formatter) patternAssign(l *listExpr, canAssign bool) fmtExpr {
+	if !canAssign || !f.check(tokenEqual) {
+		return l
+	}
+	op := f.consume(tokenEqual)
+	brk := f.broken()
+	return &binaryExpr{l, op, brk, f.expression(precAssign)}
+}
+
+// pattern parses the table or array pattern of a declaration if one
+// follows. Patterns share the syntax tree of literals.
+func (f *formatter) pattern() *listExpr {
+	if open := f.match(tokenLeftBrace); open != nil {
+		return f.table(open)
+	} else if open := f.match(tokenLeftBracket); open != nil {
+		return f.list(open, tokenRightBracket)
+	}
+	return nil
+}
+
+// list parses call arguments and array elements, which both allow spreads.
+func (f *formatter) list(open *fmtToken, closing tokenType) *listExpr {
+	l := &listExpr{open: open}
+	if !f.check(closing) {
+		for {
+			e := &elem{brk: f.broken()}
+			if e.rest = f.match(tokenDotDotDot); e.rest != nil {
+				e.value = &atomExpr{f.consume(tokenName)}
+			} else if f.check(tokenName) && f.next.tokenType == tokenColon {
+				e.name = f.consume(tokenName)
+				e.colon = f.consume(tokenColon)
+				e.value = f.expression(precAssign)
+			} else {
+				e.value = f.expression(precAssign)
+				e.spread = f.match(tokenDotDotDot)
+			}
+			l.elems = append(l.elems, e)
+			if e.comma = f.match(tokenComma); e.comma == nil {
+				break
+			}
+			if f.check(closing) || (closing == tokenRightParen && e.spread != nil) {
+				break
+			}
+		}
+	}
+	f.closeList(l, closing)
+	return l
+}
+
+func (f *formatter) table(open *fmtToken) *listExpr {
+	l := &listExpr{open: open}
+	if !f.check(tokenRightBrace) {
+		for {
+			e := &elem{brk: f.broken()}
+			if e.dot = f.match(tokenDot); e.dot != nil {
+				e.name = f.consume(tokenName)
+				if e.eq = f.match(tokenEqual); e.eq != nil {
+					e.value = f.expression(precAssign)
+				} else if f.check(tokenLeftParen) ||
+					f.check(tokenEqualRightAngle) ||
+					f.check(tokenLeftBrace) {
+					e.fn = f.function(nil)
+				}
+			} else if e.open = f.match(tokenLeftBracket); e.open != nil {
+				e.key = f.expression(precComma)
+				e.close = f.consume(tokenRightBracket)
+				e.eq = f.consume(tokenEqual)
+				e.value = f.expression(precAssign)
+			} else if e.rest = f.match(tokenDotDotDot); e.rest != nil {
+				e.value = &atomExpr{f.consume(tokenName)}
+			} else if f.check(tokenName) && f.next.tokenType == tokenColon {
+				e.name = f.consume(tokenName)
+				e.colon = f.consume(tokenColon)
+				e.value = f.expression(precAssign)
+			} else {
+				e.value = f.expression(precAssign)
+				e.spread = f.match(tokenDotDotDot)
+			}
+			l.elems = append(l.elems, e)
+			if e.comma = f.match(tokenComma); e.comma == nil {
+				break
+			}
+			if f.check(tokenRightBrace) {
+				break
+			}
+		}
+	}
+	f.closeList(l, tokenRightBrace)
+	return l
+}
+
+func (f *formatter) closeList(l *listExpr, closing tokenType) {
+	l.multi = f.broken() && len(l.elems) != 0
+	for _, e := range l.elems {
+		l.multi = l.multi || e.brk
+	}
+	l.close = f.consume(closing)
+}
+
+func (f *formatter) function(kw *fmtToken) *funcExpr {
+	fn := &funcExpr{kw: kw}
+	if fn.open = f.match(tokenLeftParen); fn.open != nil {
+		if !f.check(tokenRightParen) {
+			for {
+				p := &param{dots: f.match(tokenDotDotDot)}
+				if p.dots != nil {
+					p.name = f.consume(tokenName)
+				} else if p.pattern = f.pattern(); p.pattern == nil {
+					p.name = f.consume(tokenName)
+				}
+				if p.dots == nil {
+					if p.eq = f.match(tokenEqual); p.eq != nil {
+						p.value = f.expression(precAssign)
+					}
+				}
+				fn.params = append(fn.params, p)
+				if p.comma = f.match(tokenComma); p.comma == nil {
+					break
+				}
+				if f.check(tokenRightParen) || p.dots != nil {
+					break
+				}
+			}
+		}
+		fn.close = f.consume(tokenRightParen)
+		f.ignoreNewLine()
+	}
+	if fn.arrow = f.match(tokenEqualRightAngle); fn.arrow != nil {
+		fn.body = f.expression(precAssign)
+	} else {
+		fn.block = f.block()
+	}
+	return fn
+}
+
+/* == printer =============================================================== */
+
+const fmtIndent = "  "
+
+type printer struct {
+	buf     bytes.Buffer
+	indent  int // indentation of lines that start a statement or element
+	cur     int // indentation of the current line
+	col0    bool
+	pending []comment // trailing comments to print before the next new line
+	blankOK bool      // a blank line may precede the next token
+}
+
+func (p *printer) write(s string) {
+	if p.col0 || p.buf.Len() == 0 {
+		p.buf.WriteString(strings.Repeat(fmtIndent, p.cur))
+		p.col0 = false
+	} else if len(p.pending) != 0 {
+		p.contBreak()
+		p.write(s)
+		return
+	}
+	p.buf.WriteString(s)
+}
+
+func (p *printer) newline() {
+	for _, c := range p.pending {
+		p.buf.WriteString(" " + c.text)
+	}
+	p.pending = nil
+	p.buf.WriteByte('\n')
+	p.col0 = true
+	p.cur = p.indent
+}
+
+// contBreak continues the current statement on a new, further indented line.
+func (p *printer) contBreak() {
+	p.newline()
+	p.cur = p.indent + 1
+}
+
+func (p *printer) lineBreak() {
+	if !p.col0 && p.buf.Len() != 0 {
+		p.newline()
+	}
+}
+
+func (p *printer) blankLine() {
+	if p.buf.Len() != 0 && !bytes.HasSuffix(p.buf.Bytes(), []byte("\n\n")) {
+		p.buf.WriteByte('\n')
+	}
+}
+
+func (p *printer) token(t *fmtToken) {
+	p.comments(t)
+	if t.blank && p.blankOK && p.col0 {
+		p.blankLine()
+	}
+	p.blankOK = false
+	p.write(t.literal)
+	p.pending = append(p.pending, t.trail...)
+}
+
+// drop prints the comments of a token that is left out of the output.
+func (p *printer) drop(t *fmtToken) {
+	if t != nil {
+		p.comments(t)
+		p.pending = append(p.pending, t.trail...)
+	}
+}
+
+func (p *printer) comments(t *fmtToken) {
+	for _, c := range t.lead {
+		if !c.own {
+			p.inlineComment(c, t)
+			continue
+		}
+		if !p.col0 && p.buf.Len() != 0 {
+			p.contBreak()
+		}
+		if c.blank && p.blankOK {
+			p.blankLine()
+		}
+		if c.joined {
+			p.write(c.text + " ")
+			continue
+		}
+		p.write(c.text)
+		cur := p.cur
+		p.newline()
+		p.cur = cur
+		p.blankOK = true
+	}
+}
+
+// inlineComment writes a comment leading t on the same line. Before a
+// token closing a list or separating its elements the comment stays
+// after the code it follows, as in f(a /* after */).
+func (p *printer) inlineComment(c fmtComment, t *fmtToken) {
+	last := byte(' ')
+	if !p.col0 && p.buf.Len() != 0 {
+		last = p.buf.Bytes()[p.buf.Len()-1]
+	}
+	switch {
+	case last == ' ' || !closesBefore(t):
+		p.write(c.text + " ")
+	case strings.IndexByte("([{", last) >= 0:
+		p.write(c.text)
+	default:
+		p.write(" " + c.text)
+	}
+}
+
+// closesBefore reports whether t is written right after the code before it.
+func closesBefore(t *fmtToken) bool {
+	switch t.tokenType {
+	case tokenRightParen, tokenRightBracket, tokenRightBrace, tokenComma, tokenSemicolon:
+		return true
+	}
+	return false
+}
+
+func (p *printer) stmts(list []fmtStmt, blankOK bool) {
+	for i, s := range list {
+		p.lineBreak()
+		p.blankOK = blankOK || i > 0
+		p.stmt(s)
+	}
+}
+
+func (p *printer) stmt(s fmtStmt) {
+	switch s := s.(type) {
+	case *emptyStmt:
+		if s.semi != nil {
+			p.drop(s.semi)
+		}
+	case *exprStmt:
+		p.expr(s.x)
+		p.drop(s.semi)
+	case *varStmt:
+		p.varStmt(s)
+		p.drop(s.semi)
+	case *blockStmt:
+		p.block(s)
+	case *ifStmt:
+		p.token(s.kw)
+		p.write(" ")
+		p.paren(s.open, s.cond, s.close)
+		p.body(s.then, s.thenBrk)
+		if s.elseKw == nil {
+			return
+		}
+		if _, ok := s.then.(*blockStmt); ok {
+			p.write(" ")
+		} else {
+			p.lineBreak()
+		}
+		p.token(s.elseKw)
+		if elseIf, ok := s.els.(*ifStmt); ok && !s.elseBrk {
+			p.write(" ")
+			p.stmt(elseIf)
+		} else {
+			p.body(s.els, s.elseBrk)
+		}
+	case *whileStmt:
+		p.token(s.kw)
+		p.write(" ")
+		p.paren(s.open, s.cond, s.close)
+		p.body(s.body, s.brk)
+	case *doStmt:
+		p.token(s.kw)
+		p.body(s.body, s.brk)
+		if _, ok := s.body.(*blockStmt); ok {
+			p.write(" ")
+		} else {
+			p.lineBreak()
+		}
+		p.token(s.whileKw)
+		p.write(" ")
+		p.paren(s.open, s.cond, s.close)
+		p.drop(s.semi)
+	case *forStmt:
+		p.token(s.kw)
+		p.write(" ")
+		p.token(s.open)
+		switch init := s.init.(type) {
+		case *varStmt:
+			p.varStmt(init)
+		case *exprStmt:
+			p.expr(init.x)
+		}
+		p.semicolon(s.initSemi)
+		if s.cond != nil {
+			p.write(" ")
+			p.expr(s.cond)
+		}
+		p.semicolon(s.semi)
+		if s.post != nil {
+			p.write(" ")
+			p.expr(s.post)
+		}
+		p.token(s.close)
+		p.body(s.body, s.brk)
+	case *forEachStmt:
+		p.token(s.kw)
+		p.write(" ")
+		p.token(s.open)
+		if s.pattern != nil {
+			p.expr(s.pattern)
+		} else {
+			p.token(s.name)
+		}
+		p.write(" ")
+		p.token(s.in)
+		p.write(" ")
+		p.expr(s.x)
+		p.token(s.close)
+		p.body(s.body, s.brk)
+	case *jumpStmt:
+		p.token(s.kw)
+		if s.label != nil {
+			p.write(" ")
+			p.token(s.label)
+		}
+		if s.value != nil {
+			p.write(" ")
+			p.expr(s.value)
+		}
+		p.drop(s.semi)
+	case *labelStmt:
+		p.token(s.name)
+		p.token(s.colon)
+		p.write(" ")
+		p.stmt(s.body)
+	case *tryStmt:
+		p.token(s.kw)
+		p.write(" ")
+		p.block(s.body)
+		if s.catchKw != nil {
+			p.write(" ")
+			p.token(s.catchKw)
+			if s.open != nil {
+				p.write(" ")
+				p.token(s.open)
+				p.token(s.name)
+				p.token(s.close)
+			}
+			p.write(" ")
+			p.block(s.handler)
+		}
+		if s.finallyKw != nil {
+			p.write(" ")
+			p.token(s.finallyKw)
+			p.write(" ")
+			p.block(s.finally)
+		}
+	case *importStmt:
+		p.token(s.kw)
+		p.write(" ")
+		if s.open != nil {
+			p.token(s.open)
+			for i, n := range s.names {
+				if i == 0 {
+					p.write(" ")
+				}
+				p.token(n.name)
+				p.as(n.as, n.alias)
+				if i < len(s.names)-1 {
+					p.token(n.comma)
+					p.write(" ")
+				} else if n.comma != nil {
+					p.drop(n.comma)
+				}
+			}
+			if len(s.names) != 0 {
+				p.write(" ")
+			}
+			p.token(s.close)
+			p.write(" ")
+			p.token(s.from)
+			p.write(" ")
+		}
+		p.token(s.path)
+		p.as(s.as, s.alias)
+		p.drop(s.semi)
+	case *exportStmt:
+		p.token(s.kw)
+		p.write(" ")
+		p.varStmt(s.decl)
+		p.drop(s.decl.semi)
+	default:
+		panic(unreachable)
+	}
+}
+
+func (p *printer) as(as, alias *fmtToken) {
+	if as != nil {
+		p.write(" ")
+		p.token(as)
+		p.write(" ")
+		p.token(alias)
+	}
+}
+
+func (p *printer) semicolon(t *fmtToken) {
+	if t != nil {
+		p.token(t)
+	} else {
+		p.write(";")
+	}
+}
+
+func (p *printer) paren(open *fmtToken, x fmtExpr, close *fmtToken) {
+	p.token(open)
+	p.expr(x)
+	p.token(close)
+}
+
+// body prints the statement controlled by if, else or a loop, on the same
+// line or, if it started on a new line, indented on the next one.
+func (p *printer) body(s fmtStmt, brk bool) {
+	switch s := s.(type) {
+	case *blockStmt:
+		p.write(" ")
+		p.block(s)
+	case *emptyStmt:
+		p.write(" {}")
+		p.drop(s.semi)
+	default:
+		if !brk {
+			p.write(" ")
+			p.stmt(s)
+			return
+		}
+		indent := p.indent
+		p.indent = p.cur + 1
+		p.newline()
+		p.stmt(s)
+		p.indent = indent
+	}
+}
+
+func (p *printer) varStmt(s *varStmt) {
+	p.token(s.kw)
+	p.write(" ")
+	for _, d := range s.decls {
+		if d.pattern != nil {
+			p.expr(d.pattern)
+		} else {
+			p.token(d.name)
+		}
+		if d.eq != nil {
+			p.write(" ")
+			p.token(d.eq)
+			p.write(" ")
+			p.expr(d.value)
+		} else if d.fn != nil {
+			p.function(d.fn)
+		}
+		if d.comma != nil {
+			p.token(d.comma)
+			p.write(" ")
+		}
+	}
+}
+
+func (p *printer) block(b *blockStmt) {
+	if len(b.body) == 0 && len(b.open.trail) == 0 && len(b.close.lead) == 0 {
+		p.token(b.open)
+		p.token(b.close)
+		return
+	}
+	if b.inline {
+		p.token(b.open)
+		p.write(" ")
+		p.stmt(b.body[0])
+		p.write(" ")
+		p.token(b.close)
+		return
+	}
+	open, indent := p.cur, p.indent
+	p.token(b.open)
+	p.indent = open + 1
+	p.stmts(b.body, false)
+	p.close(b.close, open, len(b.body) != 0)
+	p.indent = indent
+}
+
+// close prints the token closing a multi line block or list, after the
+// comments that precede it inside the block.
+func (p *printer) close(t *fmtToken, open int, blankOK bool) {
+	p.lineBreak()
+	p.blankOK = blankOK
+	p.comments(t)
+	p.indent, p.cur = open, open
+	p.write(t.literal)
+	p.pending = append(p.pending, t.trail...)
+}
+
+func (p *printer) expr(x fmtExpr) {
+	switch x := x.(type) {
+	case *atomExpr:
+		p.token(x.tok)
+	case *groupExpr:
+		p.paren(x.open, x.x, x.close)
+	case *unaryExpr:
+		p.token(x.op)
+		switch x.op.tokenType {
+		case tokenNot, tokenTypeOf, tokenTry:
+			p.write(" ")
+		case tokenPlus, tokenMinus, tokenPlusPlus, tokenMinusMinus:
+			// keep - -x from turning into --x
+			if u, ok := x.x.(*unaryExpr); ok &&
+				u.op.literal[0] == x.op.literal[0] {
+				p.write(" ")
+			}
+		}
+		p.expr(x.x)
+	case *postfixExpr:
+		p.expr(x.x)
+		p.token(x.op)
+	case *binaryExpr:
+		p.expr(x.x)
+		if x.op.tokenType != tokenComma {
+			p.write(" ")
+		}
+		p.token(x.op)
+		p.space(x.brk)
+		p.expr(x.y)
+	case *ternaryExpr:
+		p.expr(x.cond)
+		p.write(" ")
+		p.token(x.q)
+		p.space(x.brk)
+		p.expr(x.then)
+		p.write(" ")
+		p.token(x.colon)
+		p.space(x.colonBrk)
+		p.expr(x.otherwise)
+	case *callExpr:
+		p.expr(x.fn)
+		p.list(x.args, false)
+	case *methodExpr:
+		p.expr(x.x)
+		p.token(x.op)
+		p.token(x.name)
+		p.list(x.args, false)
+	case *indexExpr:
+		p.expr(x.x)
+		p.paren(x.open, x.key, x.close)
+	case *dotExpr:
+		p.expr(x.x)
+		p.token(x.op)
+		p.token(x.name)
+	case *formatExpr:
+		for i, part := range x.parts {
+			p.token(part)
+			p.expr(x.args[i])
+		}
+		p.token(x.end)
+	case *funcExpr:
+		p.token(x.kw)
+		p.function(x)
+	case *listExpr:
+		p.list(x, x.open.tokenType == tokenLeftBrace)
+	default:
+		panic(unreachable)
+	}
+}
+
+// space separates two parts of an expression with a space or, where the
+// source had a line break, with a continuation line.
+func (p *printer) space(brk bool) {
+	if brk {
+		p.contBreak()
+	} else {
+		p.write(" ")
+	}
+}
+
+// function prints a function after its func keyword or name.
+func (p *printer) function(fn *funcExpr) {
+	if fn.open != nil {
+		p.token(fn.open)
+		for i, param := range fn.params {
+			if param.dots != nil {
+				p.token(param.dots)
+			}
+			if param.pattern != nil {
+				p.expr(param.pattern)
+			} else {
+				p.token(param.name)
+			}
+			if param.eq != nil {
+				p.write(" ")
+				p.token(param.eq)
+				p.write(" ")
+				p.expr(param.value)
+			}
+			if i < len(fn.params)-1 {
+				p.token(param.comma)
+				p.write(" ")
+			} else {
+				p.drop(param.comma)
+			}
+		}
+		p.token(fn.close)
+	}
+	if fn.arrow != nil {
+		p.write(" ")
+		p.token(fn.arrow)
+		p.write(" ")
+		p.expr(fn.body)
+	} else {
+		p.write(" ")
+		p.block(fn.block)
+	}
+}
+
+// list prints call arguments or an array or table literal, on one line or,
+// if the source broke it over lines, one element per line.
+func (p *printer) list(l *listExpr, table bool) {
+	if !l.multi {
+		p.token(l.open)
+		if table && len(l.elems) != 0 {
+			p.write(" ")
+		}
+		for i, e := range l.elems {
+			p.elem(e)
+			if i < len(l.elems)-1 {
+				p.token(e.comma)
+				p.write(" ")
+			} else {
+				p.drop(e.comma)
+			}
+		}
+		if table && len(l.elems) != 0 {
+			p.write(" ")
+		}
+		p.token(l.close)
+		return
+	}
+
+	open, indent := p.cur, p.indent
+	p.token(l.open)
+	p.indent = open + 1
+	for i, e := range l.elems {
+		p.lineBreak()
+		p.blankOK = i > 0
+		p.elem(e)
+		if e.comma != nil {
+			p.token(e.comma)
+		} else {
+			p.write(",")
+		}
+	}
+	p.close(l.close, open, true)
+	p.indent = indent
+}
+
+func (p *printer) elem(e *elem) {
+	switch {
+	case e.dot != nil:
+		p.token(e.dot)
+		p.token(e.name)
+		if e.eq != nil {
+			p.write(" ")
+			p.token(e.eq)
+			p.write(" ")
+			p.expr(e.value)
+		} else if e.fn != nil {
+			p.function(e.fn)
+		}
+	case e.open != nil:
+		p.paren(e.open, e.key, e.close)
+		p.write(" ")
+		p.token(e.eq)
+		p.write(" ")
+		p.expr(e.value)
+	case e.colon != nil:
+		p.token(e.name)
+		p.token(e.colon)
+		p.write(" ")
+		p.expr(e.value)
+	default:
+		if e.rest != nil {
+			p.token(e.rest)
+		}
+		p.expr(e.value)
+		if e.spread != nil {
+			p.token(e.spread)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package eule
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestFormat formats every script of the repository and checks that the
+// result is stable and compiles to the same code as the original.
+func TestFormat(t *testing.T) {
+	var paths []string
+	for _, root := range []string{"../../tests", "include"} {
+		found, err := FindTestCases(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, found...)
+	}
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := compileQuiet(source)
+		if err != nil {
+			continue // compile error tests
+		}
+		t.Run(filepath.ToSlash(path), func(t *testing.T) {
+			formatted, err := Format(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again, err := Format(formatted)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, formatted)
+			}
+			if !bytes.Equal(formatted, again) {
+				t.Errorf("not stable:\n%s\nformatted again:\n%s", formatted, again)
+			}
+			got, err := compileQuiet(formatted)
+			if err != nil {
+				t.Fatalf("%v\n%s", err, formatted)
+			}
+			if !sameCode(got, want) {
+				t.Errorf("code changed:\n%s", formatted)
+			}
+		})
+	}
+}
+
+func TestFormatInlineComments(t *testing.T) {
+	tests := []struct{ source, want string }{
+		{"print(t.a /* after */)", "print(t.a /* after */)"},
+		{"print(/* before */ t.a)", "print(/* before */ t.a)"},
+		{"f(a /* x */ , b)", "f(a /* x */, b)"},
+		{"f(a, /* c */ b)", "f(a, /* c */ b)"},
+		{"f(/* none */)", "f(/* none */)"},
+		{"var x = [1 /* y */]", "var x = [1 /* y */]"},
+		{"var t = { .a = 1 /* z */ }", "var t = { .a = 1 /* z */ }"},
+		{"var y = (1 /* c */ + 2)", "var y = (1 /* c */ + 2)"},
+	}
+	for _, tt := range tests {
+		got, err := Format([]byte(tt.source + "\n"))
+		if err != nil {
+			t.Errorf("%s: %v", tt.source, err)
+		} else if string(got) != tt.want+"\n" {
+			t.Errorf("%s: got %q, want %q", tt.source, got, tt.want+"\n")
+		}
+	}
+}
+
+func compileQuiet(source []byte) (*Function, error) {
+	c := newCompiler(source)
+	c.errOut = io.Discard
+	if fn := c.compile(); fn != nil {
+		return fn, nil
+	}
+	return nil, ErrInterpretCompileError
+}
+
+// sameCode compares functions ignoring line numbers.
+func sameCode(a, b *Function) bool {
+	if a.Name != b.Name || a.ParamCount != b.ParamCount ||
+		a.Vararg != b.Vararg || !slices.Equal(a.Code, b.Code) ||
+		!slices.Equal(a.Upvals, b.Upvals) ||
+		len(a.Constants) != len(b.Constants) {
+		return false
+	}
+	for i, c := range a.Constants {
+		if fn, ok := c.(*Function); ok {
+			other, ok := b.Constants[i].(*Function)
+			if !ok || !sameCode(fn, other) {
+				return false
+			}
+		} else if c != b.Constants[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,232 @@
+package eule
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+)
+
+// FileSystem is the capability a VM's fs library operates on. Scripts can
+// only reach files the host exposes through it.
+type FileSystem interface {
+	Open(name string) (fs.File, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte) error
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	Mkdir(name string) error
+	Remove(name string) error
+	RemoveAll(name string) error
+}
+
+// HostFS grants unrestricted access to the host file system.
+func HostFS() FileSystem { return hostFS{} }
+
+// RootFS confines access to the directory dir.
+func RootFS(dir string) (FileSystem, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return rootFS{root}, nil
+}
+
+// ReadOnlyFS grants read access to fsys; writes fail with fs.ErrPermission.
+func ReadOnlyFS(fsys fs.FS) FileSystem { return readOnlyFS{fsys} }
+
+type hostFS struct{}
+
+func (hostFS) Open(name string) (fs.File, error)     { return os.Open(name) }
+func (hostFS) ReadFile(name string) ([]byte, error)  { return os.ReadFile(name) }
+func (hostFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+func (hostFS) Mkdir(name string) error               { return os.MkdirAll(name, 0o755) }
+func (hostFS) Remove(name string) error              { return os.Remove(name) }
+func (hostFS) RemoveAll(name string) error           { return os.RemoveAll(name) }
+
+func (hostFS) WriteFile(name string, data []byte) error {
+	return os.WriteFile(name, data, 0o644)
+}
+
+func (hostFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+type rootFS struct{ *os.Root }
+
+func (r rootFS) Open(name string) (fs.File, error) { return r.Root.Open(name) }
+func (r rootFS) Mkdir(name string) error           { return r.Root.MkdirAll(name, 0o755) }
+
+func (r rootFS) WriteFile(name string, data []byte) error {
+	return r.Root.WriteFile(name, data, 0o644)
+}
+
+func (r rootFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(r.Root.FS(), name)
+}
+
+type readOnlyFS struct{ fsys fs.FS }
+
+func (r readOnlyFS) Open(name string) (fs.File, error)     { return r.fsys.Open(name) }
+func (r readOnlyFS) ReadFile(name string) ([]byte, error)  { return fs.ReadFile(r.fsys, name) }
+func (r readOnlyFS) Stat(name string) (fs.FileInfo, error) { return fs.Stat(r.fsys, name) }
+
+func (r readOnlyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(r.fsys, name)
+}
+
+func (readOnlyFS) WriteFile(name string, data []byte) error { return readOnlyError("write", name) }
+func (readOnlyFS) Mkdir(name string) error                  { return readOnlyError("mkdir", name) }
+func (readOnlyFS) Remove(name string) error                 { return readOnlyError("remove", name) }
+func (readOnlyFS) RemoveAll(name string) error              { return readOnlyError("remove", name) }
+
+func readOnlyError(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+}
+
+// openFSLib opens the fs module on the file system given by WithFS.
+func openFSLib(vm *VM) (*Table, error) {
+	if vm.fs == nil {
+		return nil, errors.New("the fs library is not enabled")
+	}
+	return newFSLib(vm.fs), nil
+}
+
+func newFSLib(fsys FileSystem) *Table {
+	lib := newTable(tableCapacity, nil)
+	method := func(name String, fn func(vm *VM, fsys FileSystem, path string, values []Value) (Value, Value)) {
+		lib.Store(name, Native(func(vm *VM, values []Value) (Value, Value) {
+			path, err := argString(values, 0)
+			if err != nil {
+				return nil, err
+			}
+			return fn(vm, fsys, string(path), values[1:])
+		}))
+	}
+
+	method("readFile", fsReadFile)
+	method("writeFile", fsWriteFile)
+	method("readDir", fsReadDir)
+	method("exists", fsExists)
+	method("stat", fsStat)
+	method("mkdir", fsMkdir)
+	method("remove", fsRemove)
+	method("lines", fsLines)
+
+	return lib
+}
+
+func fsError(err error) Value {
+	return sprintString("fs: %s", err)
+}
+
+func fsReadFile(vm *VM, fsys FileSystem, path string, values []Value) (Value, Value) {
+	data, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	return String(data), nil
+}
+
+func fsWriteFile(vm *VM, fsys FileSystem, path string, values []Value) (Value, Value) {
+	if len(values) < 1 {
+		return nil, String("not enough arguments")
+	}
+	if err := fsys.WriteFile(path, []byte(toString(values[0]))); err != nil {
+		return nil, fsError(err)
+	}
+	return Nihil{}, nil
+}
+
+func fsReadDir(vm *VM, fsys FileSystem, path string, values []Value) (Value, Value) {
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	slices.Sort(names)
+
+	array := vm.newArray(len(names) + 1)
+	for i, name := range names {
+		array.Store(Number(i), String(name))
+	}
+	array.Store(magicLength, Number(len(names)))
+	return array, nil
+}
+
+func fsExists(vm *VM, fsys FileSystem, path string, values []Value) (Value, Value) {
+	_, err := fsys.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Boolean(false), nil
+	} else if err != nil {
+		return nil, fsError(err)
+	}
+	return Boolean(true), nil
+}
+
+func fsStat(vm *VM, fsys FileSystem, path string, values []Value) (Value, Value) {
+	info, err := fsys.Stat(path)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	stat := newTable(5, nil)
+	stat.Store(String("name"), String(info.Name()))
+	stat.Store(String("size"), Number(info.Size()))
+	stat.Store(String("isDir"), Boolean(info.IsDir()))
+	stat.Store(String("mode"), Number(info.Mode().Perm()))
+	stat.Store(String("modified"), Number(info.ModTime().Unix()))
+	return stat, nil
+}
+
+func fsMkdir(vm *VM, fsys FileSystem, path string, values []Value) (Value, Value) {
+	if err := fsys.Mkdir(path); err != nil {
+		return nil, fsError(err)
+	}
+	return Nihil{}, nil
+}
+
+func fsRemove(vm *VM, fsys FileSystem, path string, values []Value) (Value, Value) {
+	var err error
+	if len(values) > 0 && toBoolean(values[0]) {
+		err = fsys.RemoveAll(path)
+	} else {
+		err = fsys.Remove(path)
+	}
+	if err != nil {
+		return nil, fsError(err)
+	}
+	return Nihil{}, nil
+}
+
+// fsLines returns an iterator over the lines of a file, to be used with
+// foreach. The lines are read at once, so the file is closed even if the
+// loop breaks early.
+func fsLines(vm *VM, fsys FileSystem, path string, values []Value) (Value, Value) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, fsError(err)
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fsError(err)
+	}
+	return Native(func(vm *VM, values []Value) (Value, Value) {
+		r := newTable(2, nil)
+		if len(lines) == 0 {
+			r.Store(magicDone, Boolean(true))
+			return r, nil
+		}
+		r.Store(magicValue, String(lines[0]))
+		lines = lines[1:]
+		return r, nil
+	}), nil
+}
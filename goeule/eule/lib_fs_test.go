@@ -0,0 +1,155 @@
+package eule
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRootFS(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	secret := filepath.Join(dir, "secret.txt")
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(secret, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := RootFS(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"../secret.txt", secret, "link.txt", "sub/../../secret.txt"} {
+		if _, err := fsys.ReadFile(name); err == nil {
+			t.Errorf("read %s outside the root", name)
+		}
+	}
+	for _, name := range []string{"../escaped.txt", filepath.Join(dir, "escaped.txt")} {
+		if err := fsys.WriteFile(name, []byte("x")); err == nil {
+			t.Errorf("wrote %s outside the root", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("escaped.txt was written: %v", err)
+	}
+	if err := fsys.Remove("../secret.txt"); err == nil {
+		t.Error("removed a file outside the root")
+	}
+}
+
+func TestReadOnlyFS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fsys := ReadOnlyFS(os.DirFS(dir))
+	if data, err := fsys.ReadFile("a.txt"); err != nil || string(data) != "a" {
+		t.Errorf("read: %q, %v", data, err)
+	}
+	writes := map[string]error{
+		"write":     fsys.WriteFile("a.txt", []byte("b")),
+		"mkdir":     fsys.Mkdir("sub"),
+		"remove":    fsys.Remove("a.txt"),
+		"removeAll": fsys.RemoveAll("a.txt"),
+	}
+	for op, err := range writes {
+		if !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("%s: got %v, want permission error", op, err)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(data) != "a" {
+		t.Errorf("a.txt changed to %q", data)
+	}
+}
+
+func TestFSLib(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "lines.txt"), []byte("one\ntwo\nthree\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := RootFS(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := `import "fs"
+fs.writeFile("out.txt", "round trip")
+print(fs.readFile("out.txt"), fs.exists("out.txt"))
+foreach (line in fs.lines("lines.txt")) print(line)
+print((try fs.readFile("../out.txt")).error)
+`
+	var stdout bytes.Buffer
+	vm := New(WithFS(fsys), WithStdout(&stdout), WithStderr(io.Discard))
+	if err := vm.Interpret([]byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "round trip true\none\ntwo\nthree\ntrue\n"; stdout.String() != want {
+		t.Errorf("got %q, want %q", stdout.String(), want)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "out.txt")); string(data) != "round trip" {
+		t.Errorf("out.txt holds %q", data)
+	}
+
+	stdout.Reset()
+	vm = New(WithFS(ReadOnlyFS(os.DirFS(dir))), WithStdout(&stdout), WithStderr(io.Discard))
+	if err := vm.Interpret([]byte(`import { writeFile } from "fs"
+print((try writeFile("out.txt", "x")).error)`)); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "true\n" {
+		t.Errorf("read-only write: got %q", stdout.String())
+	}
+}
+
+// openFiles counts the files opened through it and not closed yet.
+type openFiles struct {
+	FileSystem
+	open int
+}
+
+func (o *openFiles) Open(name string) (fs.File, error) {
+	f, err := o.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	o.open++
+	return closeCounter{f, o}, nil
+}
+
+type closeCounter struct {
+	fs.File
+	o *openFiles
+}
+
+func (c closeCounter) Close() error {
+	c.o.open--
+	return c.File.Close()
+}
+
+func TestFSLinesBreak(t *testing.T) {
+	fsys := &openFiles{FileSystem: ReadOnlyFS(fstest.MapFS{
+		"lines.txt": {Data: []byte("one\ntwo\n")},
+	})}
+	source := `import "fs"
+foreach (line in fs.lines("lines.txt")) {
+  print(line)
+  break
+}
+`
+	var stdout bytes.Buffer
+	if err := New(WithFS(fsys), WithStdout(&stdout)).Interpret([]byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "one\n" || fsys.open != 0 {
+		t.Errorf("got %q with %d files open", stdout.String(), fsys.open)
+	}
+}
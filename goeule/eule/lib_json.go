@@ -0,0 +1,232 @@
+package eule
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+func newJSONLib() *Table {
+	lib := newTable(tableCapacity, nil)
+	lib.Store(String("encode"), Native(jsonEncode))
+	lib.Store(String("decode"), Native(jsonDecode))
+	return lib
+}
+
+func jsonEncode(vm *VM, values []Value) (Value, Value) {
+	if len(values) < 1 {
+		return nil, String("not enough arguments")
+	}
+	e := &jsonEncoder{vm: vm, visiting: map[*Table]empty{}}
+	if len(values) > 1 {
+		if err := e.options(values[1]); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.encode(values[0], 0); err != nil {
+		return nil, err
+	}
+	return String(e.out.String()), nil
+}
+
+func jsonDecode(vm *VM, values []Value) (Value, Value) {
+	str, err := argString(values, 0)
+	if err != nil {
+		return nil, err
+	}
+	var data any
+	if err := json.Unmarshal([]byte(str), &data); err != nil {
+		return nil, sprintString("json: %s", err)
+	}
+	return vm.fromJSON(data), nil
+}
+
+func (vm *VM) fromJSON(data any) Value {
+	switch data := data.(type) {
+	case nil:
+		return Nihil{}
+	case bool:
+		return Boolean(data)
+	case float64:
+		return Number(data)
+	case string:
+		return String(data)
+	case []any:
+		array := vm.newArray(len(data) + 1)
+		for i, v := range data {
+			array.Store(Number(i), vm.fromJSON(v))
+		}
+		array.Store(magicLength, Number(len(data)))
+		return array
+	case map[string]any:
+		tbl := newTable(len(data), nil)
+		for k, v := range data {
+			tbl.Store(String(k), vm.fromJSON(v))
+		}
+		return tbl
+	default:
+		panic(unreachable)
+	}
+}
+
+// maxIndent is the largest number of spaces json.encode indents with.
+const maxIndent = 16
+
+type jsonEncoder struct {
+	vm       *VM
+	out      strings.Builder
+	indent   string
+	visiting map[*Table]empty
+}
+
+func (e *jsonEncoder) options(opts Value) Value {
+	tbl, ok := opts.(*Table)
+	if !ok {
+		if isNihil(opts) {
+			return nil
+		}
+		return sprintString(
+			"argument 2: table expected, got %s", typeOf(opts),
+		)
+	}
+	switch indent := tbl.Load(String("indent")).(type) {
+	case Nihil:
+	case Number:
+		if indent < 0 || indent > maxIndent || indent != Number(math.Trunc(float64(indent))) {
+			return sprintString("json: indent must be an integer from 0 to %d", maxIndent)
+		}
+		e.indent = strings.Repeat(" ", int(indent))
+	case String:
+		e.indent = string(indent)
+	default:
+		return sprintString("json: invalid indent %s", typeOf(indent))
+	}
+	return nil
+}
+
+func (e *jsonEncoder) encode(v Value, depth int) Value {
+	switch v := v.(type) {
+	case Nihil:
+		e.out.WriteString("null")
+	case Boolean:
+		e.out.WriteString(v.String())
+	case Number:
+		f := float64(v)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return sprintString("json: cannot encode %s", formatNumber(v))
+		}
+		e.out.WriteString(formatNumber(v))
+	case String:
+		e.quote(string(v))
+	case *Table:
+		if mapHas(e.visiting, v) {
+			return String("json: cannot encode cyclic table")
+		}
+		e.visiting[v] = empty{}
+		defer delete(e.visiting, v)
+
+		if length, ok := e.arrayLength(v); ok {
+			return e.encodeArray(v, length, depth)
+		}
+		return e.encodeObject(v, depth)
+	default:
+		return sprintString("json: cannot encode %s", typeOf(v))
+	}
+	return nil
+}
+
+func (e *jsonEncoder) arrayLength(tbl *Table) (int, bool) {
+	if tbl.Proto != Proto(e.vm.arrayProto) {
+		return 0, false
+	}
+	length, ok := tbl.Pairs[magicLength].(Number)
+	return int(length), ok
+}
+
+func (e *jsonEncoder) encodeArray(array *Table, length, depth int) Value {
+	if length == 0 {
+		e.out.WriteString("[]")
+		return nil
+	}
+	e.out.WriteByte('[')
+	for i := range length {
+		if i != 0 {
+			e.out.WriteByte(',')
+		}
+		e.newLine(depth + 1)
+		if err := e.encode(array.Load(Number(i)), depth+1); err != nil {
+			return err
+		}
+	}
+	e.newLine(depth)
+	e.out.WriteByte(']')
+	return nil
+}
+
+func (e *jsonEncoder) encodeObject(tbl *Table, depth int) Value {
+	if len(tbl.Pairs) == 0 {
+		e.out.WriteString("{}")
+		return nil
+	}
+	keys := make([]String, 0, len(tbl.Pairs))
+	for k := range tbl.Pairs {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	e.out.WriteByte('{')
+	for i, k := range keys {
+		if i != 0 {
+			e.out.WriteByte(',')
+		}
+		e.newLine(depth + 1)
+		e.quote(string(k))
+		e.out.WriteByte(':')
+		if e.indent != "" {
+			e.out.WriteByte(' ')
+		}
+		if err := e.encode(tbl.Pairs[k], depth+1); err != nil {
+			return err
+		}
+	}
+	e.newLine(depth)
+	e.out.WriteByte('}')
+	return nil
+}
+
+func (e *jsonEncoder) newLine(depth int) {
+	if e.indent == "" {
+		return
+	}
+	e.out.WriteByte('\n')
+	for range depth {
+		e.out.WriteString(e.indent)
+	}
+}
+
+func (e *jsonEncoder) quote(str string) {
+	e.out.WriteByte('"')
+	for _, r := range str {
+		switch r {
+		case '"':
+			e.out.WriteString(`\"`)
+		case '\\':
+			e.out.WriteString(`\\`)
+		case '\n':
+			e.out.WriteString(`\n`)
+		case '\r':
+			e.out.WriteString(`\r`)
+		case '\t':
+			e.out.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&e.out, `\u%04x`, r)
+			} else {
+				e.out.WriteRune(r)
+			}
+		}
+	}
+	e.out.WriteByte('"')
+}
@@ -0,0 +1,170 @@
+package eule
+
+import "math"
+
+func newMathLib() *Table {
+	lib := newTable(tableCapacity, nil)
+
+	lib.Store(String("pi"), Number(math.Pi))
+	lib.Store(String("e"), Number(math.E))
+	lib.Store(String("inf"), Number(math.Inf(1)))
+	lib.Store(String("nan"), Number(math.NaN()))
+
+	lib.Store(String("floor"), mathUnary(math.Floor))
+	lib.Store(String("ceil"), mathUnary(math.Ceil))
+	lib.Store(String("round"), mathUnary(math.Round))
+	lib.Store(String("trunc"), mathUnary(math.Trunc))
+	lib.Store(String("abs"), mathUnary(math.Abs))
+	lib.Store(String("sqrt"), mathUnary(math.Sqrt))
+	lib.Store(String("cbrt"), mathUnary(math.Cbrt))
+	lib.Store(String("exp"), mathUnary(math.Exp))
+	lib.Store(String("log2"), mathUnary(math.Log2))
+	lib.Store(String("log10"), mathUnary(math.Log10))
+	lib.Store(String("sin"), mathUnary(math.Sin))
+	lib.Store(String("cos"), mathUnary(math.Cos))
+	lib.Store(String("tan"), mathUnary(math.Tan))
+	lib.Store(String("asin"), mathUnary(math.Asin))
+	lib.Store(String("acos"), mathUnary(math.Acos))
+	lib.Store(String("atan"), mathUnary(math.Atan))
+	lib.Store(String("sinh"), mathUnary(math.Sinh))
+	lib.Store(String("cosh"), mathUnary(math.Cosh))
+	lib.Store(String("tanh"), mathUnary(math.Tanh))
+
+	lib.Store(String("pow"), mathBinary(math.Pow))
+	lib.Store(String("atan2"), mathBinary(math.Atan2))
+
+	lib.Store(String("log"), Native(mathLog))
+	lib.Store(String("sign"), Native(mathSign))
+	lib.Store(String("min"), Native(mathMin))
+	lib.Store(String("max"), Native(mathMax))
+	lib.Store(String("hypot"), Native(mathHypot))
+	lib.Store(String("clamp"), Native(mathClamp))
+	lib.Store(String("isNaN"), Native(mathIsNaN))
+	lib.Store(String("isFinite"), Native(mathIsFinite))
+
+	return lib
+}
+
+func mathUnary(f func(float64) float64) Native {
+	return func(vm *VM, values []Value) (Value, Value) {
+		x, err := argNumber(values, 0)
+		if err != nil {
+			return nil, err
+		}
+		return Number(f(float64(x))), nil
+	}
+}
+
+func mathBinary(f func(float64, float64) float64) Native {
+	return func(vm *VM, values []Value) (Value, Value) {
+		x, err := argNumber(values, 0)
+		if err != nil {
+			return nil, err
+		}
+		y, err := argNumber(values, 1)
+		if err != nil {
+			return nil, err
+		}
+		return Number(f(float64(x), float64(y))), nil
+	}
+}
+
+func mathLog(vm *VM, values []Value) (Value, Value) {
+	x, err := argNumber(values, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) < 2 || isNihil(values[1]) {
+		return Number(math.Log(float64(x))), nil
+	}
+	base, err := argNumber(values, 1)
+	if err != nil {
+		return nil, err
+	}
+	return Number(math.Log(float64(x)) / math.Log(float64(base))), nil
+}
+
+func mathSign(vm *VM, values []Value) (Value, Value) {
+	x, err := argNumber(values, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case x > 0:
+		return Number(1), nil
+	case x < 0:
+		return Number(-1), nil
+	default:
+		return x, nil
+	}
+}
+
+func mathMin(vm *VM, values []Value) (Value, Value) {
+	return mathFold(values, math.Min)
+}
+
+func mathMax(vm *VM, values []Value) (Value, Value) {
+	return mathFold(values, math.Max)
+}
+
+func mathFold(values []Value, f func(float64, float64) float64) (Value, Value) {
+	acc, err := argNumber(values, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(values); i++ {
+		x, err := argNumber(values, i)
+		if err != nil {
+			return nil, err
+		}
+		acc = Number(f(float64(acc), float64(x)))
+	}
+	return acc, nil
+}
+
+func mathHypot(vm *VM, values []Value) (Value, Value) {
+	var acc float64
+	for i := range values {
+		x, err := argNumber(values, i)
+		if err != nil {
+			return nil, err
+		}
+		acc = math.Hypot(acc, float64(x))
+	}
+	return Number(acc), nil
+}
+
+func mathClamp(vm *VM, values []Value) (Value, Value) {
+	x, err := argNumber(values, 0)
+	if err != nil {
+		return nil, err
+	}
+	lo, err := argNumber(values, 1)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := argNumber(values, 2)
+	if err != nil {
+		return nil, err
+	}
+	if lo > hi {
+		return nil, String("clamp: lower bound greater than upper bound")
+	}
+	return Number(math.Max(float64(lo), math.Min(float64(hi), float64(x)))), nil
+}
+
+func mathIsNaN(vm *VM, values []Value) (Value, Value) {
+	x, err := argNumber(values, 0)
+	if err != nil {
+		return nil, err
+	}
+	return Boolean(math.IsNaN(float64(x))), nil
+}
+
+func mathIsFinite(vm *VM, values []Value) (Value, Value) {
+	x, err := argNumber(values, 0)
+	if err != nil {
+		return nil, err
+	}
+	return Boolean(!math.IsNaN(float64(x)) && !math.IsInf(float64(x), 0)), nil
+}
@@ -0,0 +1,57 @@
+package eule
+
+import "fmt"
+
+// ExitError is returned by Interpret when a script calls os.exit.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func newOSLib(vm *VM) *Table {
+	lib := newTable(tableCapacity, nil)
+
+	args := vm.newArray(len(vm.args) + 1)
+	for i, arg := range vm.args {
+		args.Store(Number(i), String(arg))
+	}
+	args.Store(magicLength, Number(len(vm.args)))
+	lib.Store(String("args"), args)
+
+	env := newTable(len(vm.env), nil)
+	for k, v := range vm.env {
+		env.Store(String(k), String(v))
+	}
+	lib.Store(String("env"), env)
+
+	lib.Store(String("getenv"), Native(osGetenv))
+	lib.Store(String("exit"), Native(osExit))
+
+	return lib
+}
+
+func osGetenv(vm *VM, values []Value) (Value, Value) {
+	name, err := argString(values, 0)
+	if err != nil {
+		return nil, err
+	}
+	if value, ok := vm.env[string(name)]; ok {
+		return String(value), nil
+	}
+	return Nihil{}, nil
+}
+
+func osExit(vm *VM, values []Value) (Value, Value) {
+	code := 0
+	if len(values) > 0 && !isNihil(values[0]) {
+		num, err := argNumber(values, 0)
+		if err != nil {
+			return nil, err
+		}
+		code = int(num)
+	}
+	panic(&ExitError{code})
+}
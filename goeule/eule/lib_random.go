@@ -0,0 +1,164 @@
+package eule
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+type generator struct {
+	*rand.Rand
+	src *rand.PCG
+}
+
+func newGenerator(seed uint64) *generator {
+	src := rand.NewPCG(seed, seed)
+	return &generator{rand.New(src), src}
+}
+
+func (g *generator) seed(seed uint64) {
+	g.src.Seed(seed, seed)
+}
+
+func newRandomLib(vm *VM) *Table {
+	lib := newGeneratorTable(vm, vm.random)
+	lib.Store(String("new"), Native(randomNew))
+	return lib
+}
+
+// newGeneratorTable exposes g as a table of natives. Each native accepts an
+// optional leading self argument, so both gen.int(1, 6) and gen::int(1, 6)
+// work.
+func newGeneratorTable(vm *VM, g *generator) *Table {
+	tbl := newTable(tableCapacity, nil)
+	method := func(name String, fn func(vm *VM, g *generator, values []Value) (Value, Value)) {
+		tbl.Store(name, Native(func(vm *VM, values []Value) (Value, Value) {
+			if len(values) > 0 && values[0] == Value(tbl) {
+				values = values[1:]
+			}
+			return fn(vm, g, values)
+		}))
+	}
+
+	method("seed", randomSeed)
+	method("float", randomFloat)
+	method("int", randomInt)
+	method("choice", randomChoice)
+	method("shuffle", randomShuffle)
+	method("sample", randomSample)
+
+	return tbl
+}
+
+func randomNew(vm *VM, values []Value) (Value, Value) {
+	seed := uint64(time.Now().UnixNano())
+	if len(values) > 0 && !isNihil(values[0]) {
+		var err Value
+		seed, err = argSeed(values, 0, "new")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newGeneratorTable(vm, newGenerator(seed)), nil
+}
+
+func randomSeed(vm *VM, g *generator, values []Value) (Value, Value) {
+	seed, err := argSeed(values, 0, "seed")
+	if err != nil {
+		return nil, err
+	}
+	g.seed(seed)
+	return Nihil{}, nil
+}
+
+// argSeed returns the seed given as argument i of the native name. Whole
+// numbers in the int64 range seed with their value, other finite numbers
+// with their bits, so that negative and fractional seeds differ.
+func argSeed(values []Value, i int, name string) (uint64, Value) {
+	num, err := argNumber(values, i)
+	if err != nil {
+		return 0, err
+	}
+	f := float64(num)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, String(name + ": seed must be finite")
+	}
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		return uint64(int64(f)), nil
+	}
+	return math.Float64bits(f), nil
+}
+
+func randomFloat(vm *VM, g *generator, values []Value) (Value, Value) {
+	return Number(g.Float64()), nil
+}
+
+func randomInt(vm *VM, g *generator, values []Value) (Value, Value) {
+	lo, err := argNumber(values, 0)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := argNumber(values, 1)
+	if err != nil {
+		return nil, err
+	}
+	l, h := math.Ceil(float64(lo)), math.Floor(float64(hi))
+	if math.IsNaN(l) || math.IsNaN(h) || math.IsInf(l, 0) || math.IsInf(h, 0) {
+		return nil, String("int: bounds must be finite")
+	}
+	if l > h {
+		return nil, String("int: empty range")
+	}
+	if h-l >= math.MaxInt64 {
+		return nil, String("int: range too wide")
+	}
+	return Number(l + float64(g.Int64N(int64(h-l)+1))), nil
+}
+
+func randomChoice(vm *VM, g *generator, values []Value) (Value, Value) {
+	array, length, err := argArray(values, 0)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return nil, String("choice: empty array")
+	}
+	return array.Load(Number(g.IntN(length))), nil
+}
+
+func randomShuffle(vm *VM, g *generator, values []Value) (Value, Value) {
+	array, length, err := argArray(values, 0)
+	if err != nil {
+		return nil, err
+	}
+	g.Shuffle(length, func(i, j int) {
+		vi, vj := array.Load(Number(i)), array.Load(Number(j))
+		array.Store(Number(i), vj)
+		array.Store(Number(j), vi)
+	})
+	return array, nil
+}
+
+func randomSample(vm *VM, g *generator, values []Value) (Value, Value) {
+	array, length, err := argArray(values, 0)
+	if err != nil {
+		return nil, err
+	}
+	k, err := argNumber(values, 1)
+	if err != nil {
+		return nil, err
+	}
+	if math.IsNaN(float64(k)) || k < 0 {
+		return nil, String("sample: invalid sample size")
+	}
+	if k > Number(length) {
+		return nil, String("sample: sample larger than array")
+	}
+	perm := g.Perm(length)[:int(k)]
+	sample := vm.newArray(len(perm))
+	for i, j := range perm {
+		sample.Store(Number(i), array.Load(Number(j)))
+	}
+	sample.Store(magicLength, Number(len(perm)))
+	return sample, nil
+}
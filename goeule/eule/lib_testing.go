@@ -0,0 +1,302 @@
+package eule
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WithTesting installs the unit testing globals test, expect, beforeEach
+// and afterEach. Tests registered by a script are run by RunUnitTests.
+func WithTesting() Option {
+	return func(vm *VM) { vm.testing = &testSuite{} }
+}
+
+type testSuite struct {
+	tests      []unitTest
+	beforeEach []Value
+	afterEach  []Value
+}
+
+type unitTest struct {
+	name string
+	fn   Value
+	line int
+}
+
+func newTestingLib(vm *VM) {
+	vm.Global.Store(String("test"), Native(testRegister))
+	vm.Global.Store(String("beforeEach"), Native(testBeforeEach))
+	vm.Global.Store(String("afterEach"), Native(testAfterEach))
+
+	proto := newTable(tableCapacity, nil)
+	proto.Store(String("toEqual"), matcher(1, expectEqual))
+	proto.Store(String("toBe"), matcher(1, expectBe))
+	proto.Store(String("toBeTruthy"), matcher(0, expectTruthy))
+	proto.Store(String("toBeFalsy"), matcher(0, expectFalsy))
+	proto.Store(String("toBeVoid"), matcher(0, expectVoid))
+	proto.Store(String("toThrow"), matcher(0, expectThrow))
+	vm.Global.Store(String("expect"), Native(func(vm *VM, values []Value) (Value, Value) {
+		if len(values) < 1 {
+			return nil, String("not enough arguments")
+		}
+		e := newTable(1, proto)
+		e.Store(magicValue, values[0])
+		return e, nil
+	}))
+}
+
+func testRegister(vm *VM, values []Value) (Value, Value) {
+	name, err := argString(values, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) < 2 {
+		return nil, String("not enough arguments")
+	}
+	line := vm.callerLine()
+	switch fn := values[1].(type) {
+	case *Function:
+		line = fn.Line
+	case *Closure:
+		line = fn.fn.Line
+	}
+	vm.testing.tests = append(vm.testing.tests, unitTest{string(name), values[1], line})
+	return Nihil{}, nil
+}
+
+func testBeforeEach(vm *VM, values []Value) (Value, Value) {
+	if len(values) < 1 {
+		return nil, String("not enough arguments")
+	}
+	vm.testing.beforeEach = append(vm.testing.beforeEach, values[0])
+	return Nihil{}, nil
+}
+
+func testAfterEach(vm *VM, values []Value) (Value, Value) {
+	if len(values) < 1 {
+		return nil, String("not enough arguments")
+	}
+	vm.testing.afterEach = append(vm.testing.afterEach, values[0])
+	return Nihil{}, nil
+}
+
+// matcher builds an expectation method. Matchers taking an argument can be
+// called as e::toEqual(b) or, through the accessor, as e->toEqual(b).
+func matcher(arity int, check func(vm *VM, actual Value, args []Value) Value) Native {
+	return func(vm *VM, values []Value) (Value, Value) {
+		if len(values) < 1 {
+			return nil, String("not enough arguments")
+		}
+		e, ok := values[0].(*Table)
+		if !ok {
+			return nil, String("matcher called without expectation")
+		}
+		actual := e.Load(magicValue)
+		if arity > 0 && len(values) == 1 {
+			return Native(func(vm *VM, args []Value) (Value, Value) {
+				return Nihil{}, check(vm, actual, args)
+			}), nil
+		}
+		return Nihil{}, check(vm, actual, values[1:])
+	}
+}
+
+func expectEqual(vm *VM, actual Value, args []Value) Value {
+	if len(args) < 1 {
+		return String("not enough arguments")
+	}
+	if !deepEqual(actual, args[0], map[[2]*Table]empty{}) {
+		return sprintString("expected %s to equal %s", inspect(actual), inspect(args[0]))
+	}
+	return nil
+}
+
+func expectBe(vm *VM, actual Value, args []Value) Value {
+	if len(args) < 1 {
+		return String("not enough arguments")
+	}
+	if actual != args[0] {
+		return sprintString("expected %s to be %s", inspect(actual), inspect(args[0]))
+	}
+	return nil
+}
+
+func expectTruthy(vm *VM, actual Value, args []Value) Value {
+	if !toBoolean(actual) {
+		return sprintString("expected %s to be truthy", inspect(actual))
+	}
+	return nil
+}
+
+func expectFalsy(vm *VM, actual Value, args []Value) Value {
+	if toBoolean(actual) {
+		return sprintString("expected %s to be falsy", inspect(actual))
+	}
+	return nil
+}
+
+func expectVoid(vm *VM, actual Value, args []Value) Value {
+	if !isNihil(actual) {
+		return sprintString("expected %s to be %s", inspect(actual), nihilLiteral)
+	}
+	return nil
+}
+
+func expectThrow(vm *VM, actual Value, args []Value) Value {
+	if _, err := vm.Call(actual); err == nil {
+		return String("expected function to throw")
+	} else if !errors.Is(err, ErrInterpretRuntimeError) {
+		return String(err.Error())
+	}
+	return nil
+}
+
+// inspect formats v for assertion messages, expanding nested tables.
+func inspect(v Value) string {
+	var str strings.Builder
+	inspectTo(&str, v, map[*Table]empty{})
+	return str.String()
+}
+
+func inspectTo(str *strings.Builder, v Value, seen map[*Table]empty) {
+	tbl, ok := v.(*Table)
+	if !ok {
+		if s, ok := v.(String); ok {
+			str.WriteString(strconv.Quote(string(s)))
+		} else {
+			str.WriteString(string(toString(v)))
+		}
+		return
+	}
+	if mapHas(seen, tbl) {
+		str.WriteString("<cycle>")
+		return
+	}
+	seen[tbl] = empty{}
+	defer delete(seen, tbl)
+
+	if length, ok := tbl.Pairs[magicLength].(Number); ok && len(tbl.Pairs) == int(length)+1 {
+		str.WriteByte('[')
+		for i := range int(length) {
+			if i != 0 {
+				str.WriteString(", ")
+			}
+			inspectTo(str, tbl.Load(Number(i)), seen)
+		}
+		str.WriteByte(']')
+		return
+	}
+
+	keys := slices.Sorted(maps.Keys(tbl.Pairs))
+	if len(keys) == 0 {
+		str.WriteString("{}")
+		return
+	}
+	str.WriteString("{ ")
+	for i, k := range keys {
+		if i != 0 {
+			str.WriteString(", ")
+		}
+		fmt.Fprintf(str, ".%s = ", k)
+		inspectTo(str, tbl.Pairs[k], seen)
+	}
+	str.WriteString(" }")
+}
+
+func deepEqual(a, b Value, seen map[[2]*Table]empty) bool {
+	ta, ok1 := a.(*Table)
+	tb, ok2 := b.(*Table)
+	if !ok1 || !ok2 {
+		return a == b
+	}
+	if ta == tb || mapHas(seen, [2]*Table{ta, tb}) {
+		return true
+	}
+	seen[[2]*Table{ta, tb}] = empty{}
+	if len(ta.Pairs) != len(tb.Pairs) {
+		return false
+	}
+	for k, va := range ta.Pairs {
+		vb, ok := tb.Pairs[k]
+		if !ok || !deepEqual(va, vb, seen) {
+			return false
+		}
+	}
+	return true
+}
+
+// callerLine is the line of the script instruction calling a native.
+func (vm *VM) callerLine() int {
+	if vm.cst == 0 {
+		return 0
+	}
+	frame := &vm.callStack[vm.cst-1]
+	return frame.fn.Lines[max(frame.cursor-1, 0)]
+}
+
+// UnitResult is the outcome of one test registered with test().
+type UnitResult struct {
+	Name    string
+	Line    int
+	Failure string
+	// FailureLine is the line the failing expectation was raised at.
+	FailureLine int
+	Elapsed     time.Duration
+}
+
+func (r *UnitResult) Passed() bool { return r.Failure == "" }
+
+// RunUnitTests runs source in a fresh VM with the testing globals and then
+// runs every registered test, calling the beforeEach and afterEach hooks
+// around each of them.
+func RunUnitTests(source []byte, opts ...Option) ([]*UnitResult, error) {
+	vm := New(append(opts, WithTesting())...)
+	if err := vm.Interpret(source); err != nil {
+		return nil, err
+	}
+
+	var results []*UnitResult
+	for _, t := range vm.testing.tests {
+		r := &UnitResult{Name: t.name, Line: t.line}
+		start := time.Now()
+		phase, err := vm.runUnitTest(t)
+		r.Elapsed = time.Since(start)
+		if err != nil {
+			r.Failure = err.Error()
+			var rerr *RuntimeError
+			if errors.As(err, &rerr) {
+				r.Failure = rerr.Message
+				if len(rerr.Trace) != 0 {
+					r.FailureLine = rerr.Trace[0].Line
+				}
+			}
+			if phase != "" {
+				r.Failure = phase + ": " + r.Failure
+			}
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// runUnitTest returns the error failing t and the hook it came from, if any.
+func (vm *VM) runUnitTest(t unitTest) (string, error) {
+	for _, hook := range vm.testing.beforeEach {
+		if _, err := vm.Call(hook); err != nil {
+			return "beforeEach", err
+		}
+	}
+	phase := ""
+	_, err := vm.Call(t.fn)
+	for _, hook := range vm.testing.afterEach {
+		if _, herr := vm.Call(hook); herr != nil && err == nil {
+			phase, err = "afterEach", herr
+		}
+	}
+	return phase, err
+}
@@ -0,0 +1,485 @@
+package eule
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Lint rules, used in diagnostics and in lint directives.
+const (
+	ruleUnusedLocal     = "unused-local"
+	ruleUnusedParam     = "unused-param"
+	ruleUndefinedGlobal = "undefined-global"
+	ruleShadow          = "shadow"
+	ruleUnreachable     = "unreachable"
+	ruleUnusedLabel     = "unused-label"
+	ruleArity           = "arity"
+	ruleNaNCompare      = "nan-compare"
+	ruleDirective       = "directive"
+)
+
+var lintRules = map[string]empty{
+	ruleUnusedLocal:     {},
+	ruleUnusedParam:     {},
+	ruleUndefinedGlobal: {},
+	ruleShadow:          {},
+	ruleUnreachable:     {},
+	ruleUnusedLabel:     {},
+	ruleArity:           {},
+	ruleNaNCompare:      {},
+}
+
+// Diagnostic is a compile error or a likely mistake found by Lint. Column
+// counts bytes from 1 and is 0 when the diagnostic is about the whole line.
+// Rule is empty for compile errors.
+type Diagnostic struct {
+	Line    int
+	Column  int
+	Rule    string
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("ln %d: %s (%s)", d.Line, d.Message, d.Rule)
+}
+
+// Lint compiles source and reports likely mistakes the compiler accepts:
+// unused locals and parameters, assignments to globals that are neither
+// declared in source nor defined in vm, shadowed variables, unreachable
+// code, unused labels, calls with the wrong number of arguments to
+// functions declared in source, and comparisons with nan.
+//
+// Rules can be switched off with comments:
+//
+//	# lint:disable rule, ...   until lint:enable, all rules if none given
+//	# lint:enable rule, ...
+//	# lint:ignore rule, ...    on this and the next line
+//
+// Compile errors are written to the standard error of vm and returned as
+// ErrInterpretCompileError.
+func (vm *VM) Lint(source []byte) ([]Diagnostic, error) {
+	c, l := newLintCompiler(source, vm.stderr)
+	if c.compile() == nil {
+		return nil, ErrInterpretCompileError
+	}
+	l.finish(vm.Global)
+	return l.filter(c.comments), nil
+}
+
+func newLinter() *linter {
+	return &linter{
+		globals: map[string]*globalSym{},
+		params:  map[*Function][]string{},
+	}
+}
+
+type linter struct {
+	diags   []Diagnostic
+	globals map[string]*globalSym
+	stores  []globalStore
+	calls   []lintCall
+	jumped  bool // last statement was a return, break or continue
+
+	// the index used by Analysis
+	refs   []reference
+	params map[*Function][]string
+
+	fn    *Function // last compiled function
+	load  varRef    // last variable load
+	nan   varRef    // last load of nan
+	array codeSpan  // last array literal
+}
+
+// symbol is what the linter knows about a local variable.
+type symbol struct {
+	name      string
+	line, col int
+	endLine   int // last line of its scope
+	owner     *Function
+	param     bool
+	used      bool
+	assigned  bool
+	fn        *Function // declared as var name() {}
+	array     bool      // initialized with an array literal
+}
+
+type globalSym struct {
+	name      string
+	line, col int // of the first definition
+	defined   int
+	assigned  bool
+	fn        *Function
+	array     bool
+}
+
+// reference is an occurrence of a variable name in source, declarations
+// included. Either sym is set or name is a global.
+type reference struct {
+	sym       *symbol
+	name      string
+	fn        *Function
+	line, col int
+}
+
+type globalStore struct {
+	name string
+	line int
+}
+
+// varRef locates the end of the code loading a variable.
+type varRef struct {
+	fn   *Function
+	end  int
+	sym  *symbol
+	name string
+}
+
+// codeSpan is the code compiled for an expression.
+type codeSpan struct {
+	fn         *Function
+	start, end int
+}
+
+type lintCall struct {
+	callee varRef
+	args   int
+	spread bool
+	line   int
+}
+
+var jumpTokens = map[tokenType]empty{
+	tokenReturn:   {},
+	tokenBreak:    {},
+	tokenContinue: {},
+	tokenThrow:    {},
+}
+
+func (l *linter) report(line int, rule, format string, a ...any) {
+	l.diags = append(l.diags, Diagnostic{line, 0, rule, fmt.Sprintf(format, a...)})
+}
+
+func (c *compiler) lintReport(line int, rule, format string, a ...any) {
+	if c.lint != nil {
+		c.lint.report(line, rule, format, a...)
+	}
+}
+
+// lookup resolves name like resolveLocal and resolveUpval do, without
+// capturing anything.
+func (c *compiler) lookup(name string) *symbol {
+	for fc := c; fc != nil; fc = fc.enclosing {
+		if i, ok := fc.resolveLocal(name); ok {
+			return fc.locals[i].sym
+		}
+	}
+	return nil
+}
+
+func (c *compiler) lintDeclare() {
+	if c.lint == nil {
+		return
+	}
+	local := &c.locals[len(c.locals)-1]
+	local.sym = &symbol{
+		name:  local.name,
+		line:  c.previous.line,
+		col:   c.previous.col,
+		owner: c.fn,
+	}
+	if strings.HasPrefix(local.name, "@") {
+		local.sym.used = true
+		return
+	}
+	c.lint.refs = append(c.lint.refs,
+		reference{local.sym, local.name, c.fn, c.previous.line, c.previous.col})
+	if shadowed := c.lookup(local.name); shadowed != nil {
+		c.lint.report(c.previous.line, ruleShadow,
+			"'%s' shadows the variable declared on ln %d",
+			local.name, shadowed.line)
+	}
+}
+
+func (c *compiler) lintParam() {
+	if c.lint != nil {
+		c.locals[len(c.locals)-1].sym.param = true
+	}
+}
+
+func (c *compiler) lintDefine(tok token, isFunction bool) {
+	if c.lint == nil {
+		return
+	}
+	name := tok.literal
+	var fn *Function
+	if isFunction {
+		fn = c.lint.fn
+	}
+	if c.scope > 0 {
+		c.locals[len(c.locals)-1].sym.fn = fn
+		return
+	}
+	g := c.lint.globals[name]
+	if g == nil {
+		g = &globalSym{name: name, line: tok.line, col: tok.col}
+		c.lint.globals[name] = g
+	}
+	c.lint.refs = append(c.lint.refs,
+		reference{nil, name, c.fn, tok.line, tok.col})
+	g.defined++
+	g.fn = fn
+}
+
+func (c *compiler) lintArray(start int) {
+	if c.lint != nil {
+		c.lint.array = codeSpan{c.fn, start, len(c.fn.Code)}
+	}
+}
+
+// lintIsArray reports whether the expression compiled from start on is
+// nothing but an array literal.
+func (c *compiler) lintIsArray(start int) bool {
+	return c.lint != nil && c.lint.array == codeSpan{c.fn, start, len(c.fn.Code)}
+}
+
+// lintArrayVariable notes that the variable just defined holds an array.
+func (c *compiler) lintArrayVariable(tok token) {
+	if c.scope > 0 {
+		c.locals[len(c.locals)-1].sym.array = true
+	} else {
+		c.lint.globals[tok.literal].array = true
+	}
+}
+
+func (c *compiler) lintLoad(tok token) {
+	if c.lint == nil {
+		return
+	}
+	name := tok.literal
+	sym := c.lookup(name)
+	if sym != nil {
+		sym.used = true
+	}
+	c.lint.refs = append(c.lint.refs,
+		reference{sym, name, c.fn, tok.line, tok.col})
+	c.lint.load = varRef{c.fn, len(c.fn.Code), sym, name}
+	if name == "nan" {
+		c.lint.nan = c.lint.load
+	}
+}
+
+func (c *compiler) lintLoadKey(name string) {
+	if c.lint != nil && name == "nan" {
+		c.lint.nan = varRef{fn: c.fn, end: len(c.fn.Code)}
+	}
+}
+
+func (c *compiler) lintStore(tok token) {
+	if c.lint == nil {
+		return
+	}
+	name := tok.literal
+	sym := c.lookup(name)
+	if sym != nil {
+		sym.assigned = true
+	} else {
+		c.lint.stores = append(c.lint.stores, globalStore{name, tok.line})
+	}
+	c.lint.refs = append(c.lint.refs,
+		reference{sym, name, c.fn, tok.line, tok.col})
+}
+
+func (c *compiler) lintIsNaN() bool {
+	return c.lint != nil &&
+		c.lint.nan.fn == c.fn && c.lint.nan.end == len(c.fn.Code)
+}
+
+func (c *compiler) lintCompareNaN(left bool) {
+	if left || c.lintIsNaN() {
+		c.lint.report(c.previous.line, ruleNaNCompare,
+			"nan is not equal to anything, use math.isNaN")
+	}
+}
+
+// lintCallee returns the variable being called if the callee expression
+// is nothing but a variable.
+func (c *compiler) lintCallee() *varRef {
+	if c.lint == nil || c.lint.load.fn != c.fn || c.lint.load.end != len(c.fn.Code) {
+		return nil
+	}
+	ref := c.lint.load
+	return &ref
+}
+
+func (c *compiler) lintCall(callee *varRef, args uint8, spread bool) {
+	if callee != nil {
+		c.lint.calls = append(c.lint.calls,
+			lintCall{*callee, int(args), spread, c.previous.line})
+	}
+}
+
+func (c *compiler) lintUnreachable() {
+	if c.lint == nil || !c.lint.jumped {
+		return
+	}
+	c.lint.jumped = false
+	if !c.check(tokenRightBrace) && !c.check(tokenEof) {
+		c.lint.report(c.current.line, ruleUnreachable, "unreachable code")
+	}
+}
+
+// lintPop checks a local going out of scope.
+func (c *compiler) lintPop(local localVar) {
+	if c.lint == nil || local.sym == nil {
+		return
+	}
+	local.sym.endLine = c.previous.line
+	if local.sym.used || strings.HasPrefix(local.name, "_") {
+		return
+	}
+	if local.sym.param {
+		c.lint.report(local.sym.line, ruleUnusedParam,
+			"parameter '%s' is never used", local.name)
+	} else {
+		c.lint.report(local.sym.line, ruleUnusedLocal,
+			"variable '%s' is never used", local.name)
+	}
+}
+
+// lintFunction checks the locals of a finished function body, which are
+// never popped by endScope.
+func (c *compiler) lintFunction() {
+	if c.lint == nil {
+		return
+	}
+	var params []string
+	for _, local := range c.locals {
+		if local.sym != nil && local.sym.param {
+			params = append(params, local.name)
+		}
+		c.lintPop(local)
+	}
+	c.lint.params[c.fn] = params
+	c.lint.fn = c.fn
+}
+
+// finish runs the checks that need the whole source.
+func (l *linter) finish(builtins *Table) {
+	for _, s := range l.stores {
+		if _, ok := l.globals[s.name]; !ok && !bool(builtins.Has(String(s.name))) {
+			l.report(s.line, ruleUndefinedGlobal,
+				"assignment to undefined global '%s'", s.name)
+		}
+		if g, ok := l.globals[s.name]; ok {
+			g.assigned = true
+		}
+	}
+
+	for _, call := range l.calls {
+		var fn *Function
+		if sym := call.callee.sym; sym != nil {
+			if !sym.assigned {
+				fn = sym.fn
+			}
+		} else if g := l.globals[call.callee.name]; g != nil &&
+			g.defined == 1 && !g.assigned {
+			fn = g.fn
+		}
+		if fn == nil || call.spread {
+			continue
+		}
+		if call.args < fn.Required || (!fn.Vararg && call.args > fn.ParamCount) {
+			l.report(call.line, ruleArity,
+				"'%s' expects %s, called with %d",
+				call.callee.name, fn.arity(), call.args)
+		}
+	}
+	slices.SortStableFunc(l.diags, func(a, b Diagnostic) int {
+		return a.Line - b.Line
+	})
+}
+
+func arguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
+
+// arity describes the number of arguments f takes.
+func (f *Function) arity() string {
+	switch {
+	case f.Vararg:
+		return "at least " + arguments(f.Required)
+	case f.Required < f.ParamCount:
+		return fmt.Sprintf("%d to %s", f.Required, arguments(f.ParamCount))
+	}
+	return arguments(f.ParamCount)
+}
+
+type lintDirective struct {
+	line   int
+	action string
+	rules  []string
+}
+
+// filter drops the diagnostics switched off by directives in comments.
+func (l *linter) filter(comments []comment) []Diagnostic {
+	var directives []lintDirective
+	for _, c := range comments {
+		text := strings.TrimPrefix(c.text, "#")
+		if strings.HasPrefix(text, "/*") {
+			text = strings.TrimSuffix(text[2:], "*/")
+		}
+		text = strings.TrimSpace(text)
+		rest, ok := strings.CutPrefix(text, "lint:")
+		if !ok {
+			continue
+		}
+		action, list, _ := strings.Cut(rest, " ")
+		d := lintDirective{line: c.line, action: action}
+		for rule := range strings.SplitSeq(list, ",") {
+			rule = strings.TrimSpace(rule)
+			if rule == "" {
+				continue
+			}
+			if !mapHas(lintRules, rule) {
+				l.report(c.line, ruleDirective, "unknown lint rule '%s'", rule)
+			}
+			d.rules = append(d.rules, rule)
+		}
+		switch action {
+		case "disable", "enable", "ignore":
+			directives = append(directives, d)
+		default:
+			l.report(c.line, ruleDirective, "unknown lint directive '%s'", action)
+		}
+	}
+
+	matches := func(d lintDirective, rule string) bool {
+		return len(d.rules) == 0 || slices.Contains(d.rules, rule)
+	}
+	var diags []Diagnostic
+	for _, diag := range l.diags {
+		enabled := true
+		for _, d := range directives {
+			switch {
+			case d.line >= diag.Line && d.action != "ignore":
+			case !matches(d, diag.Rule):
+			case d.action == "disable":
+				enabled = false
+			case d.action == "enable":
+				enabled = true
+			case d.line == diag.Line || d.line == diag.Line-1:
+				enabled = false
+			}
+		}
+		if enabled || diag.Rule == ruleDirective {
+			diags = append(diags, diag)
+		}
+	}
+	slices.SortStableFunc(diags, func(a, b Diagnostic) int {
+		return a.Line - b.Line
+	})
+	return diags
+}
@@ -0,0 +1,61 @@
+package eule
+
+import (
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		source string
+		rules  []string
+	}{
+		{"var f(a, b) => a + b\nf(1)\n", []string{ruleArity}},
+		{"var f(_a, ...b) => b\nf(1, 2, 3)\nf(b...)\n", nil},
+		{"var f(a) => a\nf = print\nf(1, 2)\n", nil},
+		{"var f(a, b = 1) => a + b\nf(1)\nf(b: 2, a: 1)\nf()\n", []string{ruleArity}},
+		{"x = 1\n", []string{ruleUndefinedGlobal}},
+		{"var x\nx = 1\nprint = nihil\n", nil},
+		{"var f() {\n  var a = 1\n}\n", []string{ruleUnusedLocal}},
+		{"var f(a, _b) {\n  return 1\n}\n", []string{ruleUnusedParam}},
+		{"var f(a) => func => a\n", nil},
+		{"var f(a) {\n  var g(a) => a\n  return g\n}\n", []string{ruleUnusedParam, ruleShadow}},
+		{"var f() {\n  return 1\n  print(2)\n}\n", []string{ruleUnreachable}},
+		{"while (true) {\n  break\n  print(1)\n}\n", []string{ruleUnreachable}},
+		{"var f() {\n  throw 1\n  print(2)\n}\n", []string{ruleUnreachable}},
+		{"var f() {\n  try {\n    return 1\n  } finally {\n    var a = 1\n  }\n}\n", []string{ruleUnusedLocal}},
+		{"a: while (true) {\n  break\n}\n", []string{ruleUnusedLabel}},
+		{"a: while (true) {\n  break a\n}\n", nil},
+		{"var x = 1\nprint(x == math.nan, nan != x)\n", []string{ruleNaNCompare, ruleNaNCompare}},
+		{"x = 1 # lint:ignore undefined-global\n", nil},
+		{"# lint:disable\nx = 1\n# lint:enable\ny = 2\n", []string{ruleUndefinedGlobal}},
+		{"# lint:disable arity\nx = 1\n", []string{ruleUndefinedGlobal}},
+		{"# lint:disable nonsense\n", []string{ruleDirective}},
+	}
+	vm := New(WithStderr(io.Discard))
+	for _, tt := range tests {
+		diags, err := vm.Lint([]byte(tt.source))
+		if err != nil {
+			t.Errorf("%q: %v", tt.source, err)
+			continue
+		}
+		var rules []string
+		for _, d := range diags {
+			rules = append(rules, d.Rule)
+		}
+		if !slices.Equal(rules, tt.rules) {
+			t.Errorf("%q: got %v, want %v", tt.source, diags, tt.rules)
+		}
+	}
+}
+
+func TestLintPrelude(t *testing.T) {
+	diags, err := New().Lint(include)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diags {
+		t.Error(d)
+	}
+}
@@ -0,0 +1,273 @@
+package eule
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	pathpkg "path"
+	"strings"
+	"sync"
+)
+
+const moduleExt = ".eul"
+
+// Module is a library of natives and constants written in Go. Scripts
+// import it by name like a script module, for example
+//
+//	eule.RegisterModule(&eule.Module{
+//		Name: "billing",
+//		Members: map[string]eule.Value{
+//			"currency": eule.String("EUR"),
+//			"invoice":  eule.Native(invoice),
+//		},
+//	})
+//
+// lets scripts write import "billing" or import { invoice } from "billing".
+// Every VM importing the module gets its own table of the members, which
+// should not be modified once the module is registered.
+//
+// A module whose natives depend on the VM sets Open instead of Members.
+// Open builds the table of the members the first time a VM imports the
+// module; an error makes the import fail.
+type Module struct {
+	Name    string
+	Members map[string]Value
+	Open    func(vm *VM) (*Table, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]*Module{}
+)
+
+func init() {
+	for _, m := range []*Module{
+		{Name: "math", Members: members(newMathLib())},
+		{Name: "json", Members: members(newJSONLib())},
+		{Name: "random", Open: func(vm *VM) (*Table, error) { return newRandomLib(vm), nil }},
+		{Name: "os", Open: func(vm *VM) (*Table, error) { return newOSLib(vm), nil }},
+		{Name: "fs", Open: openFSLib},
+	} {
+		RegisterModule(m)
+	}
+}
+
+// RegisterModule makes m importable by every VM. It panics if the name is
+// not a valid module path or a module of that name is registered already.
+func RegisterModule(m *Module) {
+	if !fs.ValidPath(m.Name) || m.Name == "." {
+		panic(fmt.Sprintf("eule: invalid module name %q", m.Name))
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[m.Name]; ok {
+		panic(fmt.Sprintf("eule: module %q registered twice", m.Name))
+	}
+	registry[m.Name] = m
+}
+
+// WithModule makes m importable by the VM only, in place of any registered
+// module of the same name.
+func WithModule(m *Module) Option {
+	return func(vm *VM) { vm.natives[m.Name] = m }
+}
+
+// nativeModule returns the Go module named name, or nil.
+func (vm *VM) nativeModule(name string) *Module {
+	if m, ok := vm.natives[name]; ok {
+		return m
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return registry[name]
+}
+
+// nativeExports returns the table of the Go module named name, building
+// it the first time the VM asks for it, or nil if there is no such module.
+func (vm *VM) nativeExports(name string) (*Table, error) {
+	native := vm.nativeModule(name)
+	if native == nil {
+		return nil, nil
+	}
+	m, ok := vm.modules[name]
+	if !ok {
+		exports, err := native.table(vm)
+		if err != nil {
+			return nil, fmt.Errorf("cannot import '%s': %v", name, err)
+		}
+		m = &module{name: name, exports: exports}
+		vm.modules[name] = m
+	}
+	return m.exports, nil
+}
+
+func (m *Module) table(vm *VM) (*Table, error) {
+	if m.Open != nil {
+		return m.Open(vm)
+	}
+	t := newTable(len(m.Members), nil)
+	for name, value := range m.Members {
+		t.Store(String(name), value)
+	}
+	return t, nil
+}
+
+func members(t *Table) map[string]Value {
+	m := make(map[string]Value, len(t.Pairs))
+	for name, value := range t.Pairs {
+		m[string(name)] = value
+	}
+	return m
+}
+
+// ModuleLoader loads the source of the modules scripts import. Names are
+// slash separated paths valid for fs.ValidPath and ending in .eul: imports
+// relative to the importing module, starting with ./ or ../, are resolved
+// before Load is called, others are taken from the root of the loader.
+type ModuleLoader interface {
+	Load(name string) ([]byte, error)
+}
+
+// FSLoader loads modules from fsys, such as an os.DirFS or an embed.FS.
+func FSLoader(fsys fs.FS) ModuleLoader { return fsLoader{fsys} }
+
+type fsLoader struct{ fsys fs.FS }
+
+func (l fsLoader) Load(name string) ([]byte, error) {
+	return fs.ReadFile(l.fsys, name)
+}
+
+// MapLoader loads modules from memory, mapping names to sources.
+func MapLoader(sources map[string]string) ModuleLoader { return mapLoader(sources) }
+
+type mapLoader map[string]string
+
+func (l mapLoader) Load(name string) ([]byte, error) {
+	source, ok := l[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return []byte(source), nil
+}
+
+// WithModules lets scripts import the modules loader provides. The main
+// script imports relative to the root of the loader.
+func WithModules(loader ModuleLoader) Option {
+	return func(vm *VM) { vm.loader = loader }
+}
+
+// module is an imported script or Go module. The globals of a script fall
+// back to the VM's.
+type module struct {
+	name    string
+	globals *Table
+	exports *Table
+	loading bool
+}
+
+// resolveModule returns the name of the module path imported from the
+// module named from, which is empty for the main script.
+func resolveModule(from, path string) (string, error) {
+	name := pathpkg.Clean(path)
+	if strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+		name = pathpkg.Join(pathpkg.Dir(from), path)
+	}
+	if pathpkg.Ext(name) == "" {
+		name += moduleExt
+	}
+	if !fs.ValidPath(name) {
+		return "", fmt.Errorf("invalid module path '%s'", path)
+	}
+	return name, nil
+}
+
+// importModule returns the exports of the module path imported by fn,
+// running the module the first time it is imported. Paths naming a Go
+// module take precedence over scripts.
+func (vm *VM) importModule(fn *Function, path string) (*Table, error) {
+	if exports, err := vm.nativeExports(path); exports != nil || err != nil {
+		return exports, err
+	}
+
+	from := ""
+	if fn.module != nil {
+		from = fn.module.name
+	}
+	name, err := resolveModule(from, path)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := vm.modules[name]; ok {
+		if m.loading {
+			i := len(vm.importing) - 1
+			for vm.importing[i] != name {
+				i--
+			}
+			cycle := append(vm.importing[i:], name)
+			return nil, fmt.Errorf("import cycle %s", strings.Join(cycle, " -> "))
+		}
+		return m.exports, nil
+	}
+
+	if vm.loader == nil {
+		return nil, fmt.Errorf("cannot import '%s': modules are not enabled", path)
+	}
+	source, err := vm.loader.Load(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("module '%s' not found", name)
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot import '%s': %v", name, err)
+	}
+	c := newCompiler(source)
+	c.errOut = vm.stderr
+	script := c.compile()
+	if script == nil {
+		return nil, fmt.Errorf("cannot import '%s': compile error", name)
+	}
+
+	m := &module{name: name, globals: newTable(tableCapacity, nil), loading: true}
+	script.setModule(m)
+	if vm.strict {
+		script.setStrict()
+	}
+	if vm.coverage != nil {
+		vm.coverage.add(name, source, script)
+	}
+	vm.modules[name] = m
+	vm.importing = append(vm.importing, name)
+	result, err := vm.Call(script)
+	slicePop(&vm.importing)
+	m.loading = false
+	if err != nil {
+		delete(vm.modules, name)
+		var rerr *RuntimeError
+		if errors.As(err, &rerr) && len(rerr.Trace) != 0 {
+			return nil, fmt.Errorf("%s:%d: %s", name, rerr.Trace[0].Line, rerr.Message)
+		}
+		return nil, err
+	}
+	m.exports, _ = result.(*Table)
+	if m.exports == nil {
+		m.exports = newTable(0, nil)
+	}
+	return m.exports, nil
+}
+
+// setModule makes f and the functions nested in it run in module m.
+func (f *Function) setModule(m *module) {
+	f.module = m
+	for _, c := range f.Constants {
+		if fn, ok := c.(*Function); ok {
+			fn.setModule(m)
+		}
+	}
+}
+
+// globalScope returns the table holding the global name for frame: the
+// globals of its module or, for builtins and the main script, vm.Global.
+func (vm *VM) globalScope(frame *callFrame, name String) *Table {
+	if _, ok := frame.globals.Pairs[name]; !ok {
+		return vm.Global
+	}
+	return frame.globals
+}
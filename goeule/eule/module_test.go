@@ -0,0 +1,192 @@
+package eule
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestResolveModule(t *testing.T) {
+	tests := []struct {
+		from, path, name string
+	}{
+		{"", "util", "util.eul"},
+		{"", "./lib/util.eul", "lib/util.eul"},
+		{"lib/a.eul", "./b", "lib/b.eul"},
+		{"lib/a.eul", "../b", "b.eul"},
+		{"lib/a.eul", "b", "b.eul"},
+		{"", "../b", ""},
+		{"", "/etc/passwd", ""},
+	}
+	for _, tt := range tests {
+		name, err := resolveModule(tt.from, tt.path)
+		if name != tt.name || (err != nil) != (tt.name == "") {
+			t.Errorf("resolve %q from %q: got %q, %v, want %q", tt.path, tt.from, name, err, tt.name)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	loaders := map[string]ModuleLoader{
+		"map": MapLoader(map[string]string{
+			"lib/counter.eul": "var n = 0\nprint(\"init\")\nexport var next() => ++n\n",
+			"lib/twice.eul":   "import { next } from \"./counter\"\nexport var twice() => next() + next()\n",
+		}),
+		"fs": FSLoader(fstest.MapFS{
+			"lib/counter.eul": {Data: []byte("var n = 0\nprint(\"init\")\nexport var next() => ++n\n")},
+			"lib/twice.eul":   {Data: []byte("import { next } from \"./counter\"\nexport var twice() => next() + next()\n")},
+		}),
+	}
+	source := `import "lib/twice"
+import { next } from "lib/counter"
+print(twice.twice(), next())
+print(typeof n)
+`
+	for name, loader := range loaders {
+		var stdout, stderr bytes.Buffer
+		vm := New(WithModules(loader), WithStdout(&stdout), WithStderr(&stderr))
+		vm.Global.Store(String("n"), String("main"))
+		if err := vm.Interpret([]byte(source)); err != nil {
+			t.Errorf("%s: %v: %s", name, err, stderr.String())
+		}
+		if want := "init\n3 3\nstring\n"; stdout.String() != want {
+			t.Errorf("%s: got %q, want %q", name, stdout.String(), want)
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	loader := MapLoader(map[string]string{
+		"a.eul":      "import \"b\"\nexport var a = 1\n",
+		"b.eul":      "import { a } from \"a\"\n",
+		"broken.eul": "export var x = 1\nx()\n",
+		"syntax.eul": "var = 1\n",
+	})
+	tests := []struct {
+		source, message string
+	}{
+		{`import "a"`, "a.eul:1: b.eul:1: import cycle a.eul -> b.eul -> a.eul"},
+		{`import "broken"`, "broken.eul:2: number is not callable (global 'x')"},
+		{`import "syntax"`, "cannot import 'syntax.eul': compile error"},
+		{`import "../up"`, "invalid module path '../up'"},
+	}
+	for _, tt := range tests {
+		vm := New(WithModules(loader), WithStderr(io.Discard))
+		err := vm.Interpret([]byte(tt.source))
+		var rerr *RuntimeError
+		if !errors.As(err, &rerr) || rerr.Message != tt.message {
+			t.Errorf("%s: got %v, want %s", tt.source, err, tt.message)
+		}
+	}
+
+	err := New(WithStderr(io.Discard)).Interpret([]byte(`import "a"`))
+	if err == nil || !strings.Contains(err.Error(), "modules are not enabled") {
+		t.Errorf("import without loader: %v", err)
+	}
+	for _, source := range []string{"var f() {\n  export var x\n}\n", "import \"my-lib\"\n"} {
+		if _, err := Compile([]byte(source)); err == nil {
+			t.Errorf("%q compiles", source)
+		}
+	}
+}
+
+func TestGoModule(t *testing.T) {
+	invoice := Native(func(vm *VM, args []Value) (Value, Value) {
+		amount, err := argNumber(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return String(fmt.Sprintf("%.2f EUR", float64(amount))), nil
+	})
+	RegisterModule(&Module{
+		Name: "test/billing",
+		Members: map[string]Value{
+			"currency": String("EUR"),
+			"invoice":  invoice,
+		},
+	})
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "test/billing")
+		registryMu.Unlock()
+	}()
+
+	source := `import "test/billing"
+import { invoice, currency as cur } from "test/billing"
+import { pi } from "math"
+print(billing.invoice(12), invoice(3), cur, billing == (func {
+  import "test/billing"
+  return billing
+})())
+print(pi > 3, typeof billing.tax)
+var globals = [math, json]
+print((func {
+  import "math"
+  import "json"
+  return math == globals[0] and json == globals[1]
+})())
+`
+	var stdout bytes.Buffer
+	vm := New(WithGlobalLibs(), WithStdout(&stdout), WithStderr(io.Discard))
+	if err := vm.Interpret([]byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "12.00 EUR 3.00 EUR EUR true\ntrue void\ntrue\n"; stdout.String() != want {
+		t.Errorf("got %q, want %q", stdout.String(), want)
+	}
+
+	stdout.Reset()
+	vm = New(WithStdout(&stdout), WithModule(&Module{
+		Name:    "test/billing",
+		Members: map[string]Value{"currency": String("CHF")},
+	}))
+	if err := vm.Interpret([]byte("import { currency } from \"test/billing\"\nprint(currency)\n")); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "CHF\n" {
+		t.Errorf("WithModule: got %q", stdout.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering math twice does not panic")
+		}
+	}()
+	RegisterModule(&Module{Name: "math"})
+}
+
+func TestStdlibModules(t *testing.T) {
+	source := `import "os"
+import { int } from "random"
+import { exists } from "fs"
+var gen = (func {
+  import "random"
+  return random.new(1)
+})()
+print(os.args[0], int(1, 1), gen::int(2, 2), exists("missing"))
+`
+	var stdout bytes.Buffer
+	vm := New(WithFS(ReadOnlyFS(fstest.MapFS{})), WithArgs("a"), WithStdout(&stdout))
+	// the libraries are only globals with WithGlobalLibs
+	for _, name := range globalLibs {
+		if _, ok := vm.Global.Pairs[String(name)]; ok {
+			t.Errorf("%s is a global", name)
+		}
+	}
+	if err := vm.Interpret([]byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a 1 2 false\n"; stdout.String() != want {
+		t.Errorf("got %q, want %q", stdout.String(), want)
+	}
+
+	err := New(WithStderr(io.Discard)).Interpret([]byte(`import "fs"`))
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) || !strings.Contains(rerr.Message, "not enabled") {
+		t.Errorf("fs without WithFS: got %v", err)
+	}
+}
@@ -0,0 +1,507 @@
+package eule
+
+import (
+	"cmp"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Profile records the instructions a VM executes and the wall time it
+// spends, by call stack and line. The time of a line includes the natives
+// it calls.
+//
+// A sampling profile, started by StartSampling, records instead the call
+// stack and line running at every tick of a clock. It costs less than
+// counting every instruction, at the price of precision.
+type Profile struct {
+	File     string // source file of the profiled script, for pprof
+	Start    time.Time
+	Duration time.Duration
+	Interval time.Duration // between samples, zero if not sampling
+
+	sample atomic.Bool   // a sample is due
+	stop   chan struct{} // stops the ticker of a sampling profile
+
+	root   *profileNode
+	node   *profileNode // the call running
+	depth  int          // the frame count of node
+	line   int
+	counts *profileCounts
+	last   time.Time // when the line of counts started running, or the last sample
+}
+
+// profileNode is a function in the call tree, called from line of the
+// parent function.
+type profileNode struct {
+	fn       *Function
+	line     int
+	parent   *profileNode
+	children map[profileKey]*profileNode
+	lines    map[int]*profileCounts
+}
+
+type profileKey struct {
+	fn   *Function
+	line int
+}
+
+type profileCounts struct {
+	instructions uint64
+	wall         time.Duration
+	samples      uint64
+}
+
+// ProfileEntry is the cost of a function or a line. Flat counts what ran
+// in the function or on the line itself, Cum adds the functions it called.
+type ProfileEntry struct {
+	Function     string
+	Line         int // where the function is declared, or the line
+	Instructions uint64
+	CumInstr     uint64
+	Wall         time.Duration
+	CumWall      time.Duration
+	Samples      uint64
+	CumSamples   uint64
+}
+
+// StartProfile starts profiling the scripts the VM runs, replacing any
+// running profile.
+func (vm *VM) StartProfile() {
+	vm.StopProfile()
+	p := &Profile{Start: time.Now(), root: newProfileNode(nil, 0, nil)}
+	p.node = p.root
+	vm.profile = p
+}
+
+// StartSampling starts a sampling profile of the scripts the VM runs, taking
+// a sample every interval, and replaces any running profile. A sample due
+// while a native runs is taken when it returns.
+func (vm *VM) StartSampling(interval time.Duration) {
+	vm.StartProfile()
+	p := vm.profile
+	p.Interval, p.last = interval, p.Start
+	p.stop = make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.sample.Store(true)
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopProfile stops profiling and returns the profile, or nil if the VM was
+// not profiling.
+func (vm *VM) StopProfile() *Profile {
+	p := vm.profile
+	if p == nil {
+		return nil
+	}
+	if p.stop != nil {
+		close(p.stop)
+	}
+	p.Duration = time.Since(p.Start)
+	vm.profile = nil
+	return p
+}
+
+func newProfileNode(fn *Function, line int, parent *profileNode) *profileNode {
+	return &profileNode{
+		fn:       fn,
+		line:     line,
+		parent:   parent,
+		children: map[profileKey]*profileNode{},
+		lines:    map[int]*profileCounts{},
+	}
+}
+
+func (n *profileNode) counts(line int) *profileCounts {
+	c, ok := n.lines[line]
+	if !ok {
+		c = &profileCounts{}
+		n.lines[line] = c
+	}
+	return c
+}
+
+func (n *profileNode) child(fn *Function, line int) *profileNode {
+	key := profileKey{fn, line}
+	child, ok := n.children[key]
+	if !ok {
+		child = newProfileNode(fn, line, n)
+		n.children[key] = child
+	}
+	return child
+}
+
+// step counts the instruction about to run in frame, or samples it when a
+// sample is due.
+func (p *Profile) step(vm *VM, frame *callFrame) {
+	if p.Interval != 0 {
+		if p.sample.Load() {
+			p.sample.Store(false)
+			node := p.root
+			for i := range vm.cst {
+				node = node.child(vm.callStack[i].fn, vm.callLine(i))
+			}
+			// the ticks can come late on a busy machine, so the sample
+			// stands for the time since the previous one
+			now := time.Now()
+			c := node.counts(frame.fn.Lines[frame.cursor])
+			c.samples++
+			c.wall += now.Sub(p.last)
+			p.last = now
+		}
+		return
+	}
+	node := p.node
+	if vm.cst != p.depth || node.fn != frame.fn {
+		node = p.enter(vm)
+	}
+	if line := frame.fn.Lines[frame.cursor]; line != p.line || node != p.node {
+		now := time.Now()
+		if p.counts != nil {
+			p.counts.wall += now.Sub(p.last)
+		}
+		p.node, p.line, p.last = node, line, now
+		p.counts = node.counts(line)
+	}
+	p.counts.instructions++
+}
+
+// enter finds the node of the running call after a call or a return.
+func (p *Profile) enter(vm *VM) *profileNode {
+	node := p.node
+	defer func() { p.depth = vm.cst }()
+	if vm.cst == p.depth+1 && node.fn == vm.callerFn(vm.cst-1) {
+		return node.child(vm.callStack[vm.cst-1].fn, vm.callLine(vm.cst-1))
+	}
+	for depth := p.depth; depth > vm.cst; depth-- {
+		node = node.parent
+	}
+	if p.depth >= vm.cst && node.fn == vm.callStack[vm.cst-1].fn {
+		return node
+	}
+	node = p.root
+	for i := range vm.cst {
+		node = node.child(vm.callStack[i].fn, vm.callLine(i))
+	}
+	return node
+}
+
+// callerFn returns the function calling frame i, nil for the first.
+func (vm *VM) callerFn(i int) *Function {
+	if i == 0 {
+		return nil
+	}
+	return vm.callStack[i-1].fn
+}
+
+// callLine returns the line frame i was called from, 0 for the first.
+func (vm *VM) callLine(i int) int {
+	if i == 0 {
+		return 0
+	}
+	caller := &vm.callStack[i-1]
+	return caller.fn.Lines[caller.cursor-1]
+}
+
+// walk calls visit for every line of every call in the tree, with the
+// functions on the call stack, innermost first.
+func (p *Profile) walk(visit func(stack []*profileNode, line int, c *profileCounts)) {
+	var rec func(n *profileNode, stack []*profileNode)
+	rec = func(n *profileNode, stack []*profileNode) {
+		if n.fn != nil {
+			stack = append([]*profileNode{n}, stack...)
+			for line, c := range n.lines {
+				visit(stack, line, c)
+			}
+		}
+		for _, child := range n.children {
+			rec(child, stack)
+		}
+	}
+	rec(p.root, nil)
+}
+
+// Instructions returns the number of instructions profiled.
+func (p *Profile) Instructions() uint64 {
+	var total uint64
+	p.walk(func(_ []*profileNode, _ int, c *profileCounts) {
+		total += c.instructions
+	})
+	return total
+}
+
+// Samples returns the number of samples taken.
+func (p *Profile) Samples() uint64 {
+	var total uint64
+	p.walk(func(_ []*profileNode, _ int, c *profileCounts) {
+		total += c.samples
+	})
+	return total
+}
+
+// Functions returns the cost of every function run, most samples or
+// instructions first.
+func (p *Profile) Functions() []ProfileEntry {
+	entries := map[*Function]*ProfileEntry{}
+	entry := func(fn *Function) *ProfileEntry {
+		e, ok := entries[fn]
+		if !ok {
+			e = &ProfileEntry{Function: fn.Name, Line: fn.Line}
+			entries[fn] = e
+		}
+		return e
+	}
+	p.walk(func(stack []*profileNode, _ int, c *profileCounts) {
+		entry(stack[0].fn).addFlat(c)
+		seen := map[*Function]bool{}
+		for _, n := range stack {
+			if !seen[n.fn] { // recursion counts once
+				seen[n.fn] = true
+				entry(n.fn).addCum(c)
+			}
+		}
+	})
+	return sortEntries(entries)
+}
+
+// Lines returns the cost of every line run, most samples or instructions
+// first. Cum is the cost of the line including the calls it made.
+func (p *Profile) Lines() []ProfileEntry {
+	entries := map[profileKey]*ProfileEntry{}
+	entry := func(fn *Function, line int) *ProfileEntry {
+		e, ok := entries[profileKey{fn, line}]
+		if !ok {
+			e = &ProfileEntry{Function: fn.Name, Line: line}
+			entries[profileKey{fn, line}] = e
+		}
+		return e
+	}
+	p.walk(func(stack []*profileNode, line int, c *profileCounts) {
+		entry(stack[0].fn, line).addFlat(c)
+		seen := map[profileKey]bool{}
+		for i, n := range stack {
+			key := profileKey{n.fn, line}
+			if i > 0 {
+				key.line = stack[i-1].line
+			}
+			if !seen[key] {
+				seen[key] = true
+				entry(key.fn, key.line).addCum(c)
+			}
+		}
+	})
+	return sortEntries(entries)
+}
+
+func (e *ProfileEntry) addFlat(c *profileCounts) {
+	e.Instructions += c.instructions
+	e.Wall += c.wall
+	e.Samples += c.samples
+}
+
+func (e *ProfileEntry) addCum(c *profileCounts) {
+	e.CumInstr += c.instructions
+	e.CumWall += c.wall
+	e.CumSamples += c.samples
+}
+
+func sortEntries[K comparable](entries map[K]*ProfileEntry) []ProfileEntry {
+	var sorted []ProfileEntry
+	for _, e := range entries {
+		sorted = append(sorted, *e)
+	}
+	slices.SortFunc(sorted, func(a, b ProfileEntry) int {
+		return cmp.Or(
+			cmp.Compare(b.Samples, a.Samples),
+			cmp.Compare(b.CumSamples, a.CumSamples),
+			cmp.Compare(b.Instructions, a.Instructions),
+			cmp.Compare(b.CumInstr, a.CumInstr),
+			cmp.Compare(a.Function, b.Function),
+			cmp.Compare(a.Line, b.Line),
+		)
+	})
+	return sorted
+}
+
+// WriteReport writes the n most expensive functions and lines to w.
+func (p *Profile) WriteReport(w io.Writer, n int) error {
+	unit, total := "instructions", p.Instructions()
+	cost := func(e ProfileEntry) (flat, cum uint64) { return e.Instructions, e.CumInstr }
+	if p.Interval != 0 {
+		unit, total = "samples", p.Samples()
+		cost = func(e ProfileEntry) (flat, cum uint64) { return e.Samples, e.CumSamples }
+	}
+	percent := func(count uint64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return 100 * float64(count) / float64(total)
+	}
+	table := func(title string, entries []ProfileEntry, name func(e ProfileEntry) string) {
+		fmt.Fprintf(w, "\n%s by %s:\n", title, unit)
+		fmt.Fprintf(w, "%12s %6s %12s %6s %10s %10s  %s\n",
+			"flat", "flat%", "cum", "cum%", "wall", "cum wall", "")
+		for _, e := range entries[:min(n, len(entries))] {
+			flat, cum := cost(e)
+			fmt.Fprintf(w, "%12d %5.1f%% %12d %5.1f%% %10v %10v  %s\n",
+				flat, percent(flat), cum, percent(cum),
+				e.Wall.Round(time.Microsecond), e.CumWall.Round(time.Microsecond), name(e))
+		}
+	}
+
+	if p.Interval != 0 {
+		fmt.Fprintf(w, "profile: %d samples every %v, %v wall\n",
+			total, p.Interval, p.Duration.Round(time.Microsecond))
+	} else {
+		fmt.Fprintf(w, "profile: %d instructions, %v wall\n", total, p.Duration.Round(time.Microsecond))
+	}
+	table("functions", p.Functions(), func(e ProfileEntry) string {
+		if e.Line == 0 {
+			return "fn " + e.Function
+		}
+		return fmt.Sprintf("fn %s (ln %d)", e.Function, e.Line)
+	})
+	table("lines", p.Lines(), func(e ProfileEntry) string {
+		return fmt.Sprintf("ln %d: fn %s", e.Line, e.Function)
+	})
+	return nil
+}
+
+/* == pprof ================================================================= */
+
+// WritePprof writes the profile in the gzipped protocol buffer format of
+// pprof, with instruction counts and wall time by call stack, or for a
+// sampling profile sample counts and the wall time they stand for.
+func (p *Profile) WritePprof(w io.Writer) error {
+	b := &pprofBuilder{
+		strings:   map[string]int{"": 0},
+		functions: map[*Function]uint64{},
+		locations: map[profileKey]uint64{},
+	}
+	b.stringTable = []string{""}
+
+	first, period := "instructions", uint64(1)
+	if p.Interval != 0 {
+		first, period = "samples", uint64(p.Interval.Nanoseconds())
+	}
+	var msg []byte
+	for _, t := range [][2]string{{first, "count"}, {"wall", "nanoseconds"}} {
+		msg = pbBytes(msg, 1, b.valueType(t[0], t[1]))
+	}
+	p.walk(func(stack []*profileNode, line int, c *profileCounts) {
+		var locations []uint64
+		for i, n := range stack {
+			at := line
+			if i > 0 {
+				at = stack[i-1].line
+			}
+			locations = append(locations, b.location(n.fn, at, p.File))
+		}
+		values := []uint64{c.instructions, uint64(c.wall.Nanoseconds())}
+		if p.Interval != 0 {
+			values[0] = c.samples
+		}
+		var sample []byte
+		sample = pbPacked(sample, 1, locations)
+		sample = pbPacked(sample, 2, values)
+		msg = pbBytes(msg, 2, sample)
+	})
+	msg = append(msg, b.out...)
+	for _, s := range b.stringTable {
+		msg = pbBytes(msg, 6, []byte(s))
+	}
+	msg = pbUint(msg, 9, uint64(p.Start.UnixNano()))
+	msg = pbUint(msg, 10, uint64(p.Duration.Nanoseconds()))
+	msg = pbBytes(msg, 11, b.valueType("wall", "nanoseconds"))
+	msg = pbUint(msg, 12, period)
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(msg); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+type pprofBuilder struct {
+	out         []byte // function and location messages
+	strings     map[string]int
+	stringTable []string
+	functions   map[*Function]uint64
+	locations   map[profileKey]uint64
+}
+
+func (b *pprofBuilder) string(s string) uint64 {
+	i, ok := b.strings[s]
+	if !ok {
+		i = len(b.stringTable)
+		b.strings[s] = i
+		b.stringTable = append(b.stringTable, s)
+	}
+	return uint64(i)
+}
+
+func (b *pprofBuilder) valueType(typ, unit string) []byte {
+	return pbUint(pbUint(nil, 1, b.string(typ)), 2, b.string(unit))
+}
+
+func (b *pprofBuilder) function(fn *Function, file string) uint64 {
+	id, ok := b.functions[fn]
+	if !ok {
+		id = uint64(len(b.functions) + 1)
+		b.functions[fn] = id
+		var msg []byte
+		msg = pbUint(msg, 1, id)
+		msg = pbUint(msg, 2, b.string(fn.Name))
+		msg = pbUint(msg, 3, b.string(fn.Name))
+		msg = pbUint(msg, 4, b.string(file))
+		msg = pbUint(msg, 5, uint64(fn.Line))
+		b.out = pbBytes(b.out, 5, msg)
+	}
+	return id
+}
+
+func (b *pprofBuilder) location(fn *Function, line int, file string) uint64 {
+	key := profileKey{fn, line}
+	id, ok := b.locations[key]
+	if !ok {
+		id = uint64(len(b.locations) + 1)
+		b.locations[key] = id
+		lineMsg := pbUint(pbUint(nil, 1, b.function(fn, file)), 2, uint64(line))
+		b.out = pbBytes(b.out, 4, pbBytes(pbUint(nil, 1, id), 4, lineMsg))
+	}
+	return id
+}
+
+func pbTag(buf []byte, field, wire int) []byte {
+	return binary.AppendUvarint(buf, uint64(field<<3|wire))
+}
+
+func pbUint(buf []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(pbTag(buf, field, 0), v)
+}
+
+func pbBytes(buf []byte, field int, v []byte) []byte {
+	buf = binary.AppendUvarint(pbTag(buf, field, 2), uint64(len(v)))
+	return append(buf, v...)
+}
+
+func pbPacked(buf []byte, field int, vs []uint64) []byte {
+	var packed []byte
+	for _, v := range vs {
+		packed = binary.AppendUvarint(packed, v)
+	}
+	return pbBytes(buf, field, packed)
+}
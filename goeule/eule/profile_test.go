@@ -0,0 +1,144 @@
+package eule
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const profileSource = `var fib(n) {
+  if (n < 2) return n
+  return fib(n - 1) + fib(n - 2)
+}
+print(fib(10))
+print([1, 2]::map(fib))
+`
+
+func TestProfile(t *testing.T) {
+	vm := New(WithStdout(io.Discard))
+	before := vm.Instructions()
+	vm.StartProfile()
+	if err := vm.Interpret([]byte(profileSource)); err != nil {
+		t.Fatal(err)
+	}
+	p := vm.StopProfile()
+	if vm.StopProfile() != nil {
+		t.Error("profile still running after StopProfile")
+	}
+
+	if got, want := p.Instructions(), vm.Instructions()-before; got != want {
+		t.Errorf("profiled %d instructions, the VM ran %d", got, want)
+	}
+
+	functions := map[string]ProfileEntry{}
+	for _, e := range p.Functions() {
+		functions[e.Function] = e
+	}
+	fib, script, mapFn := functions["fib"], functions["@script"], functions["map"]
+	if fib.Instructions == 0 || fib.Instructions != fib.CumInstr {
+		t.Errorf("fib: %+v", fib)
+	}
+	if script.CumInstr != p.Instructions() {
+		t.Errorf("@script: cumulative %d, want all %d", script.CumInstr, p.Instructions())
+	}
+	if mapFn.CumInstr <= mapFn.Instructions {
+		t.Errorf("map: cumulative %d does not include fib", mapFn.CumInstr)
+	}
+
+	var lines uint64
+	for _, e := range p.Lines() {
+		if e.Function == "fib" {
+			lines += e.Instructions
+			if e.Line < 2 || e.Line > 4 {
+				t.Errorf("fib ran line %d", e.Line)
+			}
+		}
+	}
+	if lines != fib.Instructions {
+		t.Errorf("fib lines add up to %d, want %d", lines, fib.Instructions)
+	}
+
+	var report strings.Builder
+	p.WriteReport(&report, 3)
+	if !strings.Contains(report.String(), "fn fib (ln 1)") ||
+		!strings.Contains(report.String(), "ln 3: fn fib") {
+		t.Errorf("report:\n%s", report.String())
+	}
+
+	var pprof bytes.Buffer
+	if err := p.WritePprof(&pprof); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&pprof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"instructions", "wall", "nanoseconds", "fib", "@script"} {
+		if !bytes.Contains(msg, []byte(s)) {
+			t.Errorf("pprof profile lacks string %q", s)
+		}
+	}
+}
+
+func TestSamplingProfile(t *testing.T) {
+	source := `var spin(n) {
+  var sum = 0
+  for (var i = 0; i < n; i += 1) sum += i
+  return sum
+}
+var start = clock()
+while (clock() - start < 0.2) spin(1000)
+`
+	vm := New(WithStdout(io.Discard))
+	vm.StartSampling(time.Millisecond)
+	if err := vm.Interpret([]byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	p := vm.StopProfile()
+	if p.Instructions() != 0 || p.Samples() == 0 {
+		t.Fatalf("got %d instructions and %d samples", p.Instructions(), p.Samples())
+	}
+
+	functions := map[string]ProfileEntry{}
+	for _, e := range p.Functions() {
+		functions[e.Function] = e
+	}
+	spin, script := functions["spin"], functions["@script"]
+	if spin.Samples == 0 || script.CumSamples != p.Samples() ||
+		script.CumWall == 0 || script.CumWall > p.Duration {
+		t.Errorf("spin: %+v, @script: %+v", spin, script)
+	}
+	for _, e := range p.Lines() {
+		if e.Function == "spin" && (e.Line < 2 || e.Line > 4) {
+			t.Errorf("spin sampled on line %d", e.Line)
+		}
+	}
+
+	var report strings.Builder
+	p.WriteReport(&report, 3)
+	if !strings.Contains(report.String(), "samples every 1ms") {
+		t.Errorf("report:\n%s", report.String())
+	}
+	var pprof bytes.Buffer
+	if err := p.WritePprof(&pprof); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&pprof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(msg, []byte("samples")) || !bytes.Contains(msg, []byte("spin")) {
+		t.Error("pprof profile lacks the samples of spin")
+	}
+}
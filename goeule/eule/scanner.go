@@ -1,6 +1,10 @@
 package eule
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"strings"
+)
 
 const eofByte = nul
 
@@ -11,6 +15,22 @@ type scanner struct {
 	line   int
 	nl     bool
 	format int
+
+	lineStart int // offset of the first byte of the current line
+
+	// keepComments makes scan record skipped comments in comments, for
+	// tools that need them such as the formatter.
+	keepComments bool
+	comments     []comment
+
+	strict bool // the source has a strict pragma, see strictPragma
+	code   bool // a token other than a newline has been scanned
+}
+
+// comment is a line or block comment with the lines it starts and ends on.
+type comment struct {
+	text      string
+	line, end int
 }
 
 func newScanner(source []byte) scanner {
@@ -28,27 +48,33 @@ func (s *scanner) scan() token {
 
 skipWhite:
 	for {
+		start, startLine := s.cursor, s.line
 		if s.current() == '#' {
 			s.skipLineComment()
+			s.addComment(start, startLine)
+			s.pragma(start)
 		} else if s.current() == '/' && s.peek() == '*' {
 			s.advance()
 			s.advance()
-			for s.current() != '*' && s.peek() != '/' {
+			for s.current() != '*' || s.peek() != '/' {
 				if s.current() == '\n' {
 					s.line++
+					s.lineStart = s.cursor + 1
 				}
 				if s.isAtEnd() {
-					return s.makeToken("unfinished block comment")
+					return s.errorToken("unfinished block comment")
 				}
 				s.advance()
 			}
 			s.advance()
 			s.advance()
+			s.addComment(start, startLine)
 		}
 
 		switch s.current() {
 		case '\n':
 			s.line++
+			s.lineStart = s.cursor + 1
 			fallthrough
 		case ' ', '\r', '\t':
 			s.advance()
@@ -61,9 +87,14 @@ skipWhite:
 
 	if modeAutoSemicolons {
 		if s.nl && line < s.line {
-			return s.makeToken(tokenNewLine)
+			// the newline belongs to the line it ends, so that the code
+			// finishing a statement is attributed to that statement
+			tk := s.makeToken(tokenNewLine)
+			tk.line = line
+			return tk
 		}
 	}
+	s.code = true
 
 	if s.isAtEnd() {
 		return s.makeToken(tokenEof)
@@ -101,6 +132,41 @@ skipWhite:
 	return s.errorToken("unexpected symbol '%c'", char)
 }
 
+// Tokens writes the tokens of source to w, one per line.
+func Tokens(w io.Writer, source []byte) {
+	s := newScanner(source)
+	for {
+		t := s.scan()
+		fmt.Fprintln(w, t)
+		if t.tokenType == tokenEof {
+			return
+		}
+	}
+}
+
+// Incomplete reports whether source ends in the middle of a statement: with
+// unclosed brackets or a trailing operator. Interactive front ends use it to
+// keep reading lines before evaluating.
+func Incomplete(source []byte) bool {
+	s := newScanner(source)
+	depth := 0
+	last := tokenEof
+	for {
+		t := s.scan()
+		switch t.tokenType {
+		case tokenLeftParen, tokenLeftBrace, tokenLeftBracket:
+			depth++
+		case tokenRightParen, tokenRightBrace, tokenRightBracket:
+			depth--
+		case tokenNewLine:
+			continue
+		case tokenEof:
+			return depth > 0 || mapHas(continueAfter, last)
+		}
+		last = t.tokenType
+	}
+}
+
 func (s *scanner) skipShebang() {
 	if s.current() == '#' && s.peek() == '!' {
 		s.skipLineComment()
@@ -113,6 +179,27 @@ func (s *scanner) skipLineComment() {
 	}
 }
 
+func (s *scanner) addComment(start, line int) {
+	if s.keepComments {
+		text := strings.TrimRight(string(s.source[start:s.cursor]), " \t\r")
+		s.comments = append(s.comments, comment{text, line, s.line})
+	}
+}
+
+// strictPragma is a comment line making a script check the number of
+// arguments of the functions it calls. It only counts before the first
+// token, so a trailing comment cannot change how the whole file runs.
+const strictPragma = "# eule:strict"
+
+func (s *scanner) pragma(start int) {
+	if s.code {
+		return
+	}
+	if strings.TrimRight(string(s.source[start:s.cursor]), " \t\r") == strictPragma {
+		s.strict = true
+	}
+}
+
 func (s *scanner) literal() string {
 	return string(s.source[s.start:s.cursor])
 }
@@ -201,7 +288,7 @@ func (s *scanner) advance() byte {
 func (s *scanner) makeToken(t tokenType) token {
 	s.nl = mapHas(insertNewLineAfter, t)
 	literal := string(s.source[s.start:s.cursor])
-	tk := token{t, literal, s.line}
+	tk := token{t, literal, s.line, s.start - s.lineStart, s.start}
 	if debugPrintTokens {
 		fmt.Println(tk)
 	}
@@ -209,7 +296,20 @@ func (s *scanner) makeToken(t tokenType) token {
 }
 
 func (s *scanner) errorToken(format string, a ...any) token {
-	return token{tokenError, fmt.Sprintf(format, a...), s.line}
+	return token{tokenError, fmt.Sprintf(format, a...), s.line, s.start - s.lineStart, s.start}
+}
+
+// isIdentifier reports whether name scans as a single name token.
+func isIdentifier(name string) bool {
+	if name == "" || !isAlpha(name[0]) || mapHas(keywords, name) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isAlpha(name[i]) && !isNumeric(name[i], 10) {
+			return false
+		}
+	}
+	return true
 }
 
 func isAlpha(char byte) bool {
@@ -248,6 +348,48 @@ var insertNewLineAfter = map[tokenType]empty{
 	tokenReturn:       {},
 }
 
+var continueAfter = map[tokenType]empty{
+	tokenComma:           {},
+	tokenDot:             {},
+	tokenBang:            {},
+	tokenQuestion:        {},
+	tokenColon:           {},
+	tokenEqual:           {},
+	tokenMinusRightAngle: {},
+	tokenEqualRightAngle: {},
+	tokenColonColon:      {},
+	tokenPlus:            {},
+	tokenMinus:           {},
+	tokenStar:            {},
+	tokenSlash:           {},
+	tokenPercent:         {},
+	tokenPlusEqual:       {},
+	tokenMinusEqual:      {},
+	tokenStarEqual:       {},
+	tokenSlashEqual:      {},
+	tokenPercentEqual:    {},
+	tokenEqualEqual:      {},
+	tokenBangEqual:       {},
+	tokenLeftAngle:       {},
+	tokenRightAngle:      {},
+	tokenLeftAngleEqual:  {},
+	tokenRightAngleEqual: {},
+	tokenPipePipe:        {},
+	tokenAmperAmper:      {},
+	tokenPipePipeEqual:   {},
+	tokenAmperAmperEqual: {},
+	tokenFormat:          {},
+	tokenAnd:             {},
+	tokenOr:              {},
+	tokenNot:             {},
+	tokenThen:            {},
+	tokenElse:            {},
+	tokenIn:              {},
+	tokenTry:             {},
+	tokenThrow:           {},
+	tokenTypeOf:          {},
+}
+
 var solo = map[byte]tokenType{
 	'(': tokenLeftParen,
 	')': tokenRightParen,
@@ -310,6 +452,7 @@ var keywords = map[string]tokenType{
 	nihilLiteral:    tokenNihil,
 	variableLiteral: tokenVariable,
 	functionLiteral: tokenFunction,
+	"const":         tokenConst,
 
 	"if":       tokenIf,
 	"else":     tokenElse,
@@ -333,6 +476,11 @@ var keywords = map[string]tokenType{
 	"in":      tokenIn,
 	"then":    tokenThen,
 	"try":     tokenTry,
+	"catch":   tokenCatch,
+	"finally": tokenFinally,
+	"throw":   tokenThrow,
+	"import":  tokenImport,
+	"export":  tokenExport,
 
 	"typeof": tokenTypeOf,
 }
@@ -61,6 +61,7 @@ const (
 
 	tokenNihil    tokenType = "nihil"
 	tokenVariable tokenType = "variable"
+	tokenConst    tokenType = "const"
 	tokenFunction tokenType = "function"
 
 	tokenFalse    tokenType = "false"
@@ -85,6 +86,11 @@ const (
 	tokenIn       tokenType = "in"
 	tokenThen     tokenType = "then"
 	tokenTry      tokenType = "try"
+	tokenCatch    tokenType = "catch"
+	tokenFinally  tokenType = "finally"
+	tokenThrow    tokenType = "throw"
+	tokenImport   tokenType = "import"
+	tokenExport   tokenType = "export"
 
 	tokenTypeOf tokenType = "typeof"
 
@@ -98,6 +104,8 @@ type token struct {
 	tokenType
 	literal string
 	line    int
+	col     int // byte offset in the line
+	pos     int // byte offset in the source
 }
 
 func (t token) String() string {
@@ -0,0 +1,78 @@
+package eule
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// TraceEvent describes an instruction the VM is about to execute.
+type TraceEvent struct {
+	Function    string
+	Line        int
+	Offset      int
+	Instruction string
+	Stack       []Value
+}
+
+func (e TraceEvent) String() string {
+	var str strings.Builder
+	fmt.Fprintf(&str, "fn %-12s %s|:", e.Function, e.Instruction)
+	for _, v := range e.Stack {
+		fmt.Fprintf(&str, " [%v]", v)
+	}
+	return str.String()
+}
+
+// TraceFilter selects trace events by function name and line range. Empty
+// fields match everything.
+type TraceFilter struct {
+	Functions []string
+	FromLine  int
+	ToLine    int
+}
+
+func (f TraceFilter) Match(e TraceEvent) bool {
+	return f.match(e.Function, e.Line)
+}
+
+func (f TraceFilter) match(function string, line int) bool {
+	if len(f.Functions) != 0 && !slices.Contains(f.Functions, function) {
+		return false
+	}
+	if f.FromLine != 0 && line < f.FromLine {
+		return false
+	}
+	if f.ToLine != 0 && line > f.ToLine {
+		return false
+	}
+	return true
+}
+
+// WriteTrace returns a tracer printing every event to w.
+func WriteTrace(w io.Writer) func(TraceEvent) {
+	return func(e TraceEvent) { fmt.Fprintln(w, e) }
+}
+
+// SetTracer makes the VM report every executed instruction matching filter
+// to tracer. Passing a nil tracer turns tracing off.
+func (vm *VM) SetTracer(tracer func(TraceEvent), filter TraceFilter) {
+	vm.tracer, vm.traceOnly = tracer, filter
+}
+
+func (vm *VM) trace(frame *callFrame) {
+	// filter first, the event copies the stack
+	if !vm.traceOnly.match(frame.fn.Name, frame.fn.Lines[frame.cursor]) {
+		return
+	}
+	var instruction strings.Builder
+	printInstruction(&instruction, frame.fn, frame.cursor)
+	vm.tracer(TraceEvent{
+		Function:    frame.fn.Name,
+		Line:        frame.fn.Lines[frame.cursor],
+		Offset:      frame.cursor,
+		Instruction: instruction.String(),
+		Stack:       slices.Clone(vm.stack[:vm.st]),
+	})
+}
@@ -0,0 +1,40 @@
+package eule
+
+import (
+	"io"
+	"testing"
+)
+
+func TestTraceFilter(t *testing.T) {
+	source := "var f(x) => x + 1\nprint(f(1))\nprint(f(2))\n"
+	var events []TraceEvent
+	vm := New(WithStdout(io.Discard))
+	vm.SetTracer(func(e TraceEvent) { events = append(events, e) },
+		TraceFilter{Functions: []string{"f"}})
+	if err := vm.Interpret([]byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 {
+		t.Fatal("no events traced")
+	}
+	for _, e := range events {
+		if e.Function != "f" {
+			t.Errorf("traced %s", e)
+		}
+	}
+
+	events = nil
+	vm.SetTracer(func(e TraceEvent) { events = append(events, e) },
+		TraceFilter{FromLine: 3, ToLine: 3})
+	if err := vm.Interpret([]byte(source)); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 {
+		t.Fatal("no events traced on line 3")
+	}
+	for _, e := range events {
+		if e.Line != 3 {
+			t.Errorf("traced line %d: %s", e.Line, e)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package eule
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 	"time"
 )
@@ -66,13 +67,42 @@ func newTable(cap int, proto Proto) *Table {
 }
 
 type Function struct {
-	Name       string      `json:"name"`
-	Code       []uint8     `json:"code"`
-	Constants  []Value     `json:"constants"`
-	Lines      []int       `json:"lines"`
-	Upvals     []compUpval `json:"upvalues"`
-	ParamCount int         `json:"parameters"`
-	Vararg     bool        `json:"vararg"`
+	Name       string         `json:"name"`
+	Line       int            `json:"line"`
+	Code       []uint8        `json:"code"`
+	Constants  []Value        `json:"constants"`
+	Lines      []int          `json:"lines"`
+	Upvals     []compUpval    `json:"upvalues"`
+	ParamCount int            `json:"parameters"`
+	Params     []string       `json:"params"`   // names, empty for patterns
+	Required   int            `json:"required"` // parameters up to the last without default
+	Vararg     bool           `json:"vararg"`
+	Strict     bool           `json:"strict"` // check the arity of the functions it calls
+	Locals     []LocalVar     `json:"locals"`
+	Callees    map[int]string `json:"callees"` // source text by call offset
+
+	module *module // set for functions of imported scripts
+}
+
+// LocalVar is debug information about a local variable of a function: it
+// is held in stack slot Slot, counted from the first parameter, while the
+// instruction offset is within [Start, End).
+type LocalVar struct {
+	Name  string `json:"name"`
+	Slot  int    `json:"slot"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// Local returns the name of the local variable in slot at the instruction
+// offset.
+func (f *Function) Local(slot, offset int) (string, bool) {
+	for _, local := range slices.Backward(f.Locals) {
+		if local.Slot == slot && local.Start <= offset && offset < local.End {
+			return local.Name, true
+		}
+	}
+	return "", false
 }
 
 func (f *Function) addConstant(constant Value) int {
@@ -134,12 +164,12 @@ func (u *Upvalue) Load() Value {
 
 func nativePrint(vm *VM, values []Value) (Value, Value) {
 	for i, value := range values {
-		fmt.Printf("%s", toPrint(value))
+		fmt.Fprint(vm.stdout, toPrint(value))
 		if i != len(values)-1 {
-			fmt.Print(" ")
+			fmt.Fprint(vm.stdout, " ")
 		}
 	}
-	fmt.Println()
+	fmt.Fprintln(vm.stdout)
 	return Nihil{}, nil
 }
 
@@ -153,6 +183,8 @@ func nativeAssert(vm *VM, values []Value) (Value, Value) {
 	}
 	if toBoolean(values[0]) {
 		return Nihil{}, nil
+	} else if len(values) > 1 {
+		return nil, sprintString("assertion failed: %s", toPrint(values[1]))
 	} else {
 		return nil, String("assertion failed")
 	}
@@ -188,6 +220,44 @@ func nativeError(vm *VM, values []Value) (Value, Value) {
 	return nil, values[0]
 }
 
+func argNumber(values []Value, i int) (Number, Value) {
+	if i >= len(values) {
+		return 0, String("not enough arguments")
+	}
+	if num, ok := values[i].(Number); ok {
+		return num, nil
+	}
+	return 0, sprintString(
+		"argument %d: number expected, got %s", i+1, typeOf(values[i]),
+	)
+}
+
+func argString(values []Value, i int) (String, Value) {
+	if i >= len(values) {
+		return "", String("not enough arguments")
+	}
+	if str, ok := values[i].(String); ok {
+		return str, nil
+	}
+	return "", sprintString(
+		"argument %d: string expected, got %s", i+1, typeOf(values[i]),
+	)
+}
+
+func argArray(values []Value, i int) (*Table, int, Value) {
+	if i >= len(values) {
+		return nil, 0, String("not enough arguments")
+	}
+	if tbl, ok := values[i].(*Table); ok {
+		if length, ok := tbl.Load(magicLength).(Number); ok {
+			return tbl, int(length), nil
+		}
+	}
+	return nil, 0, sprintString(
+		"argument %d: array expected, got %s", i+1, typeOf(values[i]),
+	)
+}
+
 func (v Nihil) String() string     { return nihilLiteral }
 func (v Boolean) String() string   { return strconv.FormatBool(bool(v)) }
 func (v Number) String() string    { return formatNumber(v) }
@@ -206,6 +276,11 @@ func (v *Function) valueMark() {}
 func (v *Closure) valueMark()  {}
 func (v Native) valueMark()    {}
 
+// Sprint formats v the way the print native does.
+func Sprint(v Value) string {
+	return toPrint(v)
+}
+
 func toPrint(v Value) string {
 	switch v := v.(type) {
 	case *Table:
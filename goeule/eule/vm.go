@@ -4,10 +4,14 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"io"
 	"maps"
 	"math"
 	"math/bits"
 	"os"
+	"slices"
+	"sync/atomic"
+	"time"
 )
 
 //go:embed include/include.eul
@@ -21,15 +25,44 @@ const (
 var (
 	ErrInterpretRuntimeError = errors.New("runtime error")
 	ErrInterpretCompileError = errors.New("compile error")
+	ErrInterrupted           = errors.New("interrupted")
 )
 
+// Exit codes for scripts that failed, following the BSD sysexits convention.
+const (
+	ExitCompileError = 65
+	ExitRuntimeError = 70
+)
+
+// ExitCode maps the result of Interpret to a process exit code.
+func ExitCode(err error) int {
+	var exit *ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exit):
+		return exit.Code
+	case errors.Is(err, ErrInterpretCompileError):
+		return ExitCompileError
+	default:
+		return ExitRuntimeError
+	}
+}
+
 type throwError error
 
 type callFrame struct {
-	fn     *Function
-	cursor int
-	slots  int
-	upvals []*Upvalue
+	fn      *Function
+	cursor  int
+	slots   int
+	upvals  []*Upvalue
+	globals *Table
+}
+
+// constKey is a global declared const in a table of globals.
+type constKey struct {
+	globals *Table
+	name    String
 }
 
 type tryHandler struct {
@@ -49,6 +82,12 @@ func (f *callFrame) readShort() uint16 {
 	return uint16(big)<<8 | uint16(small)
 }
 
+// operand describes the value n slots below the top of the stack for the
+// instruction of size bytes just read, see Function.describe.
+func (f *callFrame) operand(size, n int) string {
+	return f.fn.describe(f.cursor-size, n)
+}
+
 func (f *callFrame) readConstant() Value {
 	return f.fn.Constants[f.readByte()]
 }
@@ -66,13 +105,106 @@ type VM struct {
 	openUpvals *Upvalue
 	try        []tryHandler
 	arrayProto *Table
+	random     *generator
+	fs         FileSystem
+	args       []string
+	env        map[string]string
+	tracer     func(TraceEvent)
+	traceOnly  TraceFilter // events passed on to tracer
+	debugger   *Debugger
+	profile    *Profile
+	coverage   *Coverage
+	coverFile  string
+	loader     ModuleLoader
+	modules    map[string]*module
+	natives    map[string]*Module // Go modules given by WithModule
+	consts     map[constKey]empty
+	importing  []string // modules being run by import, innermost last
+	stdout     io.Writer
+	stderr     io.Writer
+	interrupt  atomic.Bool
+	testing    *testSuite
+	steps      uint64 // instructions run, see Instructions
+	strict     bool
+	libGlobals bool // see WithGlobalLibs
+}
+
+// Option configures a VM created by New.
+type Option func(vm *VM)
+
+// WithFS enables the fs library, confined to the given file system.
+func WithFS(fsys FileSystem) Option {
+	return func(vm *VM) { vm.fs = fsys }
+}
+
+// WithStdout redirects the output of print.
+func WithStdout(w io.Writer) Option {
+	return func(vm *VM) { vm.stdout = w }
+}
+
+// WithStderr redirects compile and runtime error reports.
+func WithStderr(w io.Writer) Option {
+	return func(vm *VM) { vm.stderr = w }
+}
+
+// WithArgs sets the script arguments exposed as os.args.
+func WithArgs(args ...string) Option {
+	return func(vm *VM) { vm.args = slices.Clone(args) }
+}
+
+// WithEnv sets the environment visible through os.env and os.getenv.
+func WithEnv(env map[string]string) Option {
+	return func(vm *VM) { vm.env = maps.Clone(env) }
 }
 
-func New() *VM {
+// globalLibs are the libraries WithGlobalLibs makes globals.
+var globalLibs = []string{"math", "random", "json", "os", "fs"}
+
+// WithGlobalLibs makes the libraries math, random, json, os and, if enabled
+// by WithFS, fs globals of the same names, for the scripts that use them
+// without an import. The globals are the tables import gives, so both ways
+// see the same members.
+func WithGlobalLibs() Option {
+	return func(vm *VM) { vm.libGlobals = true }
+}
+
+// WithStrict makes the scripts the VM compiles check the number of
+// arguments of the functions they call, like the pragma
+//
+//	# eule:strict
+//
+// does for a single file.
+func WithStrict() Option {
+	return func(vm *VM) { vm.strict = true }
+}
+
+// setStrict makes f and the functions nested in it strict.
+func (f *Function) setStrict() {
+	f.Strict = true
+	for _, c := range f.Constants {
+		if fn, ok := c.(*Function); ok {
+			fn.setStrict()
+		}
+	}
+}
+
+func New(opts ...Option) *VM {
 	vm := &VM{
 		callStack: [framesMax]callFrame{},
 		stack:     [stackMax]Value{},
 		Global:    newTable(tableCapacity, nil),
+		modules:   map[string]*module{},
+		natives:   map[string]*Module{},
+		consts:    map[constKey]empty{},
+		random:    newGenerator(uint64(time.Now().UnixNano())),
+		stdout:    os.Stdout,
+		stderr:    os.Stderr,
+	}
+	if debugTraceExecution {
+		vm.tracer = WriteTrace(os.Stdout)
+	}
+	for _, opt := range opts {
+		opt(vm)
 	}
 
 	vm.Global.Store(String("print"), Native(nativePrint))
@@ -81,52 +213,171 @@ func New() *VM {
 	vm.Global.Store(String("setPrototype"), Native(nativeSetPrototype))
 	vm.Global.Store(String("getPrototype"), Native(nativeGetPrototype))
 	vm.Global.Store(String("error"), Native(nativeError))
+	if vm.libGlobals {
+		for _, name := range globalLibs {
+			if lib, _ := vm.nativeExports(name); lib != nil {
+				vm.Global.Store(String(name), lib)
+			}
+		}
+	}
+	if vm.testing != nil {
+		newTestingLib(vm)
+	}
 
+	file, strict := vm.coverFile, vm.strict
+	vm.coverFile, vm.strict = PreludeFile, false
 	vm.Interpret(include)
+	vm.coverFile, vm.strict = file, strict
 	vm.arrayProto = vm.Global.Load(magicArray).(*Table)
 	return vm
 }
 
 func (vm *VM) Interpret(source []byte) error {
-	fn := newCompiler(source).compile()
+	_, err := vm.interpret(source, false)
+	return err
+}
+
+// Eval runs source like Interpret and returns the value of its last
+// statement if that statement is a bare expression, or void otherwise.
+func (vm *VM) Eval(source []byte) (Value, error) {
+	return vm.interpret(source, true)
+}
+
+// Run executes a script function produced by Compile or Load.
+func (vm *VM) Run(fn *Function) error {
+	if vm.coverage != nil {
+		vm.coverage.add(vm.coverFile, nil, fn)
+	}
+	_, err := vm.execute(fn)
+	return err
+}
+
+func (vm *VM) interpret(source []byte, echo bool) (Value, error) {
+	c := newCompiler(source)
+	c.errOut = vm.stderr
+	c.echo = echo
+	fn := c.compile()
 	if fn == nil {
-		return ErrInterpretCompileError
+		return nil, ErrInterpretCompileError
 	}
+	if vm.strict {
+		fn.setStrict()
+	}
+	if vm.coverage != nil {
+		vm.coverage.add(vm.coverFile, source, fn)
+	}
+	return vm.execute(fn)
+}
 
+func (vm *VM) execute(fn *Function) (Value, error) {
 	if debugPrintBytecode {
-		printBytecode(fn)
+		printBytecode(os.Stdout, fn)
 	}
 
 	vm.push(fn)
 	vm.callFunction(fn, 0, nil)
 
-	return vm.run()
+	result, err := vm.run(0)
+	var rerr *RuntimeError
+	if errors.As(err, &rerr) {
+		rerr.print(vm.stderr)
+	}
+	return result, err
+}
+
+// Call calls a script or native function with args and returns its result.
+// It can be used by the host and, re-entrantly, by natives. Uncaught errors
+// are returned as *RuntimeError without being reported.
+func (vm *VM) Call(callee Value, args ...Value) (Value, error) {
+	base, st, tries := vm.cst, vm.st, len(vm.try)
+	vm.push(callee)
+	for _, arg := range args {
+		vm.push(arg)
+	}
+	if err := vm.callValue(callee, len(args)); err != nil {
+		vm.st = st
+		return nil, &RuntimeError{Message: string(toString(err))}
+	}
+	if vm.cst == base {
+		return vm.pop(), nil
+	}
+
+	result, err := vm.run(base)
+	if err != nil && base != 0 {
+		vm.closeUpvalues(st)
+		vm.st, vm.cst = st, base
+		vm.try = vm.try[:tries]
+	}
+	return result, err
+}
+
+func (vm *VM) newArray(cap int) *Table {
+	array := newTable(cap, vm.arrayProto)
+	array.Store(magicLength, Number(0))
+	return array
+}
+
+// spreadArray appends the elements of spr from index from on to array.
+func spreadArray(array, spr *Table, from Number) {
+	length, ok := spr.Load(magicLength).(Number)
+	if !ok {
+		return
+	}
+	oldLength := array.Load(magicLength).(Number)
+	for i := from; i < length; i++ {
+		array.Store(oldLength+i-from, spr.Load(i))
+	}
+	array.Store(magicLength, oldLength+max(length-from, 0))
 }
 
 func (vm *VM) currentFrame() *callFrame {
 	return &vm.callStack[vm.cst-1]
 }
 
-func (vm *VM) run() (err error) {
+// run executes frames until the frame count drops back to base, returning
+// the value of the last returning function.
+func (vm *VM) run(base int) (result Value, err error) {
 	frame := vm.currentFrame()
 	throwString := func(format string, a ...any) {
-		if err := vm.throw(&frame, format, a...); err != nil {
+		if err := vm.throw(&frame, base, format, a...); err != nil {
 			panic(throwError(err))
 		}
 	}
-	throwValue := func(v Value) {
-		throwString("%v", v)
+	raise := func(v Value) {
+		if err := vm.raise(&frame, base, v); err != nil {
+			panic(throwError(err))
+		}
 	}
-	defer catch(func(e throwError) { err = e })
+	defer catch(func(e throwError) {
+		if base != 0 && errors.Is(e, ErrInterrupted) {
+			panic(e)
+		}
+		if base == 0 {
+			vm.resetStack()
+		}
+		err = e
+	})
+	defer catch(func(e *ExitError) {
+		if base != 0 {
+			panic(e)
+		}
+		vm.resetStack()
+		err = e
+	})
 
 	for {
-		if debugTraceExecution {
-			printInstruction(frame.fn, frame.cursor)
-			fmt.Print("|: ")
-			for i := 0; i < vm.st; i++ {
-				fmt.Printf("[%v] ", vm.stack[i])
-			}
-			fmt.Println()
+		vm.steps++
+		if vm.tracer != nil {
+			vm.trace(frame)
+		}
+		if vm.debugger != nil {
+			vm.debugger.check(frame)
+		}
+		if vm.profile != nil {
+			vm.profile.step(vm, frame)
+		}
+		if vm.coverage != nil {
+			vm.coverage.step(frame)
 		}
 
 		switch op := frame.readByte(); op {
@@ -145,6 +396,10 @@ func (vm *VM) run() (err error) {
 			r.Store(magicValue, val)
 			r.Store(magicError, Boolean(false))
 			vm.push(r)
+		case opPopTry:
+			slicePop(&vm.try)
+		case opThrow:
+			raise(vm.pop())
 		case opPop:
 			vm.pop()
 		case opDup:
@@ -168,9 +423,7 @@ func (vm *VM) run() (err error) {
 		case opTable:
 			vm.push(newTable(tableCapacity, nil))
 		case opArray:
-			array := newTable(tableCapacity, vm.arrayProto)
-			array.Store(magicLength, Number(0))
-			vm.push(array)
+			vm.push(vm.newArray(tableCapacity))
 		case opAddArrayElement:
 			array := vm.peek(1).(*Table)
 			oldLength := array.Load(magicLength).(Number)
@@ -179,25 +432,27 @@ func (vm *VM) run() (err error) {
 			array.Store(magicLength, newLength)
 		case opAddArraySpread:
 			array := vm.peek(1).(*Table)
-			oldLength := array.Load(magicLength).(Number)
-			newLength := oldLength
-
 			spr := vm.pop()
 			switch spr := spr.(type) {
 			case Nihil:
 			case *Table:
-				if length, ok := spr.Load(magicLength).(Number); ok {
-					var i Number
-					for i = 0; i < length; i++ {
-						array.Store(oldLength+i, spr.Load(i))
-					}
-					newLength = oldLength + length
-				}
+				spreadArray(array, spr, 0)
 			default:
 				throwString("attempt to spread %s", typeOf(spr))
 			}
-
-			array.Store(magicLength, newLength)
+		case opArrayRest:
+			from := Number(frame.readByte())
+			spr := vm.pop()
+			rest := vm.newArray(tableCapacity)
+			switch spr := spr.(type) {
+			case Nihil:
+				vm.push(rest)
+			case *Table:
+				spreadArray(rest, spr, from)
+				vm.push(rest)
+			default:
+				throwString("attempt to destructure %s", typeOf(spr))
+			}
 		case opClosure:
 			fn := vm.pop().(*Function)
 			cls := &Closure{fn, nil}
@@ -242,45 +497,94 @@ func (vm *VM) run() (err error) {
 			default:
 				throwString("attempt to spread %s", typeOf(spr))
 			}
+		case opTableRest:
+			n := int(frame.readByte())
+			keys := vm.stack[vm.st-n : vm.st]
+			vm.st -= n
+			spr := vm.pop()
+			rest := newTable(tableCapacity, nil)
+			switch spr := spr.(type) {
+			case Nihil:
+				vm.push(rest)
+			case *Table:
+				maps.Copy(rest.Pairs, spr.Pairs)
+				for _, key := range keys {
+					rest.Delete(key)
+				}
+				vm.push(rest)
+			default:
+				throwString("attempt to destructure %s", typeOf(spr))
+			}
 		case opStoreKey:
 			value := vm.pop()
 			key := vm.pop()
 			object := vm.pop()
 			table, ok := object.(*Table)
 			if !ok {
-				throwString("attempt to store key in %s", typeOf(object))
+				throwString("attempt to store key in %s%s",
+					typeOf(object), frame.operand(1, 2))
+			} else {
+				vm.push(table.Store(key, value))
 			}
-			vm.push(table.Store(key, value))
 		case opLoadKey:
 			key := vm.pop()
 			object := vm.pop()
 			table, ok := object.(*Table)
 			if !ok {
-				throwString("attempt to load key from %s", typeOf(object))
+				throwString("attempt to load key from %s%s",
+					typeOf(object), frame.operand(1, 1))
+			} else {
+				vm.push(table.Load(key))
 			}
-			vm.push(table.Load(key))
 		case opStoreLocal:
 			slot := int(frame.readByte())
 			vm.stack[frame.slots+slot] = vm.peek(0)
 		case opLoadLocal:
 			slot := int(frame.readByte())
 			vm.push(vm.stack[frame.slots+slot])
-		case opDefineGlobal:
+		case opDefineGlobal, opDefineConst:
 			name := frame.readString()
-			vm.Global.Pairs[name] = vm.pop()
+			key := constKey{frame.globals, name}
+			if _, ok := vm.consts[key]; ok {
+				throwString("constant '%s' is already defined", name)
+			} else {
+				frame.globals.Pairs[name] = vm.pop()
+				if op == opDefineConst {
+					vm.consts[key] = empty{}
+				}
+			}
 		case opStoreGlobal:
 			name := frame.readString()
-			if _, ok := vm.Global.Pairs[name]; !ok {
+			globals := vm.globalScope(frame, name)
+			if _, ok := globals.Pairs[name]; !ok {
 				throwString("variable '%s' is undefined", name)
+			} else if _, ok := vm.consts[constKey{globals, name}]; ok {
+				throwString("assignment to constant '%s'", name)
+			} else {
+				globals.Pairs[name] = vm.peek(0)
 			}
-			vm.Global.Pairs[name] = vm.peek(0)
 		case opLoadGlobal:
 			name := frame.readString()
-			if value, ok := vm.Global.Pairs[name]; !ok {
+			if value, ok := vm.globalScope(frame, name).Pairs[name]; !ok {
 				throwString("variable '%s' is undefined", name)
 			} else {
 				vm.push(value)
 			}
+		case opImport:
+			path := frame.readString()
+			if exports, err := vm.importModule(frame.fn, string(path)); err != nil {
+				throwString("%s", err)
+			} else {
+				vm.push(exports)
+			}
+		case opLoadExport:
+			name := frame.readString()
+			exports := vm.pop().(*Table)
+			if value, ok := exports.Pairs[name]; !ok {
+				throwString("'%s' is not exported by the module", name)
+			} else {
+				vm.push(value)
+			}
 		case opEq:
 			v2 := vm.pop()
 			v1 := vm.pop()
@@ -294,8 +598,8 @@ func (vm *VM) run() (err error) {
 				vm.push(num1 + num2)
 			} else {
 				throwString(
-					"attempt to add %s and %s",
-					typeOf(v1), typeOf(v2),
+					"attempt to add %s%s and %s%s",
+					typeOf(v1), frame.operand(1, 1), typeOf(v2), frame.operand(1, 0),
 				)
 			}
 		case opLt, opLe, opSub, opMul, opDiv, opMod:
@@ -305,8 +609,9 @@ func (vm *VM) run() (err error) {
 				vm.push(numOps[op](num1, num2))
 			} else {
 				throwString(
-					"attempt to %s %s and %s",
-					opNames[op], typeOf(v1), typeOf(v2),
+					"attempt to %s %s%s and %s%s",
+					opNames[op], typeOf(v1), frame.operand(1, 1),
+					typeOf(v2), frame.operand(1, 0),
 				)
 			}
 		case opOr:
@@ -350,22 +655,24 @@ func (vm *VM) run() (err error) {
 			v, isNumber := vm.peek(0).(Number)
 			if !isNumber {
 				throwString(
-					"attempt to %s %s",
-					opNames[op], typeOf(v),
+					"attempt to %s %s%s",
+					opNames[op], typeOf(vm.peek(0)), frame.operand(1, 0),
 				)
+			} else {
+				vm.pop()
+				vm.push(-v)
 			}
-			vm.pop()
-			vm.push(-v)
 		case opPos:
 			v, isNumber := vm.peek(0).(Number)
 			if !isNumber {
 				throwString(
-					"attempt to %s %s",
-					opNames[op], typeOf(v),
+					"attempt to %s %s%s",
+					opNames[op], typeOf(vm.peek(0)), frame.operand(1, 0),
 				)
+			} else {
+				vm.pop()
+				vm.push(Number(math.Abs(float64(v))))
 			}
-			vm.pop()
-			vm.push(Number(math.Abs(float64(v))))
 		case opTypeOf:
 			vm.push(typeOf(vm.pop()))
 		case opJump:
@@ -387,32 +694,55 @@ func (vm *VM) run() (err error) {
 			frame.cursor += offset
 		case opJumpBack:
 			frame.cursor -= int(frame.readShort())
+			vm.checkInterrupt()
 		case opCall:
+			vm.checkInterrupt()
 			argCount := int(frame.readByte())
-			if err := vm.callValue(vm.peek(argCount), argCount); err != nil {
-				throwValue(err)
+			callee := vm.peek(argCount)
+			if err := checkArity(frame, callee, argCount); err != nil {
+				raise(err)
+			} else if err := vm.callValue(callee, argCount); err != nil {
+				raise(callError(err, callee, frame, argCount))
+			}
+			frame = vm.currentFrame()
+		case opCallNamed:
+			vm.checkInterrupt()
+			argCount := int(frame.readByte())
+			named := vm.pop().(*Table)
+			callee := vm.peek(argCount)
+			if argCount, err := vm.nameArguments(frame, callee, argCount, named); err != nil {
+				raise(callError(err, callee, frame, -1))
+			} else if err := checkArity(frame, callee, argCount); err != nil {
+				raise(err)
+			} else if err := vm.callValue(callee, argCount); err != nil {
+				raise(err)
 			}
 			frame = vm.currentFrame()
 		case opCallSpread:
+			vm.checkInterrupt()
 			argCount := int(frame.readByte())
 
 			spr := vm.pop()
-			switch spr := spr.(type) {
-			case Nihil:
-			case *Table:
-				if length, ok := spr.Load(magicLength).(Number); ok {
+			if _, ok := spr.(Nihil); !ok {
+				table, ok := spr.(*Table)
+				if !ok {
+					throwString("attempt to spread %s", typeOf(spr))
+					break
+				}
+				if length, ok := table.Load(magicLength).(Number); ok {
 					var i Number
 					for i = 0; i < length; i++ {
-						vm.push(spr.Load(i))
+						vm.push(table.Load(i))
 						argCount++
 					}
 				}
-			default:
-				throwString("attempt to spread %s", typeOf(spr))
 			}
 
-			if err := vm.callValue(vm.peek(argCount), argCount); err != nil {
-				throwValue(err)
+			callee := vm.peek(argCount)
+			if err := checkArity(frame, callee, argCount); err != nil {
+				raise(err)
+			} else if err := vm.callValue(callee, argCount); err != nil {
+				raise(callError(err, callee, frame, -1))
 			}
 			frame = vm.currentFrame()
 
@@ -422,9 +752,8 @@ func (vm *VM) run() (err error) {
 			vm.st = frame.slots - 1
 			vm.push(result)
 			vm.cst--
-			if vm.cst == 0 {
-				vm.pop()
-				return nil
+			if vm.cst == base {
+				return vm.pop(), nil
 			}
 			frame = vm.currentFrame()
 		default:
@@ -480,6 +809,93 @@ func (vm *VM) callValue(value Value, argCount int) Value {
 	}
 }
 
+// callError names the callee when it is not callable: the variable holding
+// it or the source text of the callee expression. argCount is negative when
+// the arguments were not pushed by single instructions, as for spreads.
+func callError(err, callee Value, frame *callFrame, argCount int) Value {
+	switch callee.(type) {
+	case *Closure, *Function, Native:
+		return err
+	}
+	text, ok := frame.fn.Callees[frame.cursor-2]
+	if ok && !isIdentifier(text) {
+		return err.(String) + sprintString(" (expression '%s')", text)
+	}
+	if argCount >= 0 {
+		if name := frame.operand(2, argCount); name != "" {
+			return err.(String) + String(name)
+		}
+	}
+	if ok {
+		return err.(String) + sprintString(" (expression '%s')", text)
+	}
+	return err
+}
+
+// checkArity returns an error if the function running in frame is strict
+// and calls a function with a number of arguments it does not take.
+func checkArity(frame *callFrame, callee Value, argCount int) Value {
+	if !frame.fn.Strict {
+		return nil
+	}
+	var fn *Function
+	switch callee := callee.(type) {
+	case *Closure:
+		fn = callee.fn
+	case *Function:
+		fn = callee
+	default:
+		return nil
+	}
+	if argCount < fn.Required || (!fn.Vararg && argCount > fn.ParamCount) {
+		return sprintString("'%s' expects %s, got %d", fn.Name, fn.arity(), argCount)
+	}
+	return nil
+}
+
+// nameArguments passes the named arguments in the slots of the parameters
+// of callee they name, after argCount positional arguments, and returns the
+// number of arguments then on the stack. Strict code must pass every
+// parameter without default.
+func (vm *VM) nameArguments(frame *callFrame, callee Value, argCount int, named *Table) (int, Value) {
+	var fn *Function
+	switch callee := callee.(type) {
+	case *Closure:
+		fn = callee.fn
+	case *Function:
+		fn = callee
+	case Native:
+		return argCount, String("native functions take no named arguments")
+	default:
+		return argCount, sprintString("%s is not callable", typeOf(callee))
+	}
+	total := max(argCount, fn.ParamCount)
+	for range total - argCount {
+		vm.push(Nihil{})
+	}
+	base := vm.st - total
+	for _, name := range slices.Sorted(maps.Keys(named.Pairs)) {
+		i := slices.Index(fn.Params, string(name))
+		if i < 0 {
+			return argCount, sprintString("'%s' has no parameter '%s'", fn.Name, name)
+		} else if i < argCount {
+			return argCount, sprintString("'%s' got argument '%s' twice", fn.Name, name)
+		}
+		vm.stack[base+i] = named.Pairs[name]
+	}
+	if frame.fn.Strict {
+		for i := argCount; i < fn.Required; i++ {
+			if name := fn.Params[i]; name == "" {
+				return argCount, sprintString("'%s' expects %s, got %d",
+					fn.Name, fn.arity(), argCount+len(named.Pairs))
+			} else if !named.Has(String(name)) {
+				return argCount, sprintString("'%s' misses argument '%s'", fn.Name, name)
+			}
+		}
+	}
+	return total, nil
+}
+
 func (vm *VM) balanceArguments(argCount, paramCount int, hasVararg bool) {
 	var vararg Value
 
@@ -516,7 +932,11 @@ func (vm *VM) callFunction(
 	if vm.cst == framesMax {
 		return String("stack overflow")
 	}
-	vm.callStack[vm.cst] = callFrame{fn, 0, vm.st - argCount, upvals}
+	globals := vm.Global
+	if fn.module != nil {
+		globals = fn.module.globals
+	}
+	vm.callStack[vm.cst] = callFrame{fn, 0, vm.st - argCount, upvals, globals}
 	vm.cst++
 	vm.balanceArguments(argCount, fn.ParamCount, fn.Vararg)
 	return nil
@@ -533,6 +953,34 @@ func (vm *VM) callNative(fn Native, argCount int) Value {
 	}
 }
 
+// Instructions returns the number of instructions the VM has executed.
+// Unlike timings, the count does not vary between runs, so eule bench
+// reports it per run and compares it with the baseline. Counting costs an
+// increment per instruction, well below the noise of the timings.
+func (vm *VM) Instructions() uint64 {
+	return vm.steps
+}
+
+// Interrupt stops the running script at the next loop iteration or call;
+// Interpret then returns ErrInterrupted. It is safe to call from another
+// goroutine.
+func (vm *VM) Interrupt() {
+	vm.interrupt.Store(true)
+}
+
+func (vm *VM) checkInterrupt() {
+	if vm.interrupt.Load() {
+		vm.interrupt.Store(false)
+		panic(throwError(ErrInterrupted))
+	}
+}
+
+func (vm *VM) resetStack() {
+	vm.st, vm.cst = 0, 0
+	vm.try = nil
+	vm.openUpvals = nil
+}
+
 func (vm *VM) push(value Value) {
 	vm.stack[vm.st] = value
 	vm.st++
@@ -547,20 +995,27 @@ func (vm *VM) peek(distance int) Value {
 	return vm.stack[vm.st-1-distance]
 }
 
-func (vm *VM) throw(frame **callFrame, format string, a ...any) error {
-	if vm.unwind(frame) {
+func (vm *VM) throw(frame **callFrame, base int, format string, a ...any) error {
+	return vm.raise(frame, base, String(fmt.Sprintf(format, a...)))
+}
+
+// raise throws v to the innermost try handler, which gets it unchanged in
+// the value of its result. Without a handler v is an uncaught RuntimeError.
+func (vm *VM) raise(frame **callFrame, base int, v Value) error {
+	if vm.unwind(frame, base) {
 		r := newTable(2, nil)
-		r.Store(magicValue, String(fmt.Sprintf(format, a...)))
+		r.Store(magicValue, v)
 		r.Store(magicError, Boolean(true))
 		vm.push(r)
 		return nil
 	}
 
-	return vm.runtimeError(format, a...)
+	return vm.runtimeError(base, "%s", toString(v))
 }
 
-func (vm *VM) unwind(frame **callFrame) bool {
-	if len(vm.try) != 0 {
+// unwind jumps to the innermost try handler registered above base.
+func (vm *VM) unwind(frame **callFrame, base int) bool {
+	if len(vm.try) != 0 && vm.try[len(vm.try)-1].cst > base {
 		try := slicePop(&vm.try)
 		vm.st, vm.cst = try.st, try.cst
 		vm.closeUpvalues(try.st)
@@ -571,18 +1026,43 @@ func (vm *VM) unwind(frame **callFrame) bool {
 	return false
 }
 
-func (vm *VM) runtimeError(format string, a ...any) error {
-	fmt.Fprint(os.Stderr, "runtime error: ")
-	fmt.Fprintf(os.Stderr, format+"\n", a...)
+// RuntimeError is an error a script raised and did not catch.
+type RuntimeError struct {
+	Message string
+	Trace   []TraceLine
+}
 
-	for i := vm.cst - 1; i >= 0; i-- {
-		frame := &vm.callStack[i]
-		fn := frame.fn
-		line := fn.Lines[frame.cursor]
-		fmt.Fprintf(os.Stderr, "  ln %d: fn %s\n", line, fn.Name)
+// TraceLine is a call frame active when a RuntimeError was raised.
+type TraceLine struct {
+	Function string
+	Line     int
+}
+
+func (e *RuntimeError) Error() string {
+	return "runtime error: " + e.Message
+}
+
+func (e *RuntimeError) Is(target error) bool {
+	return target == ErrInterpretRuntimeError
+}
+
+func (e *RuntimeError) print(w io.Writer) {
+	fmt.Fprintln(w, e.Error())
+	for _, frame := range e.Trace {
+		fmt.Fprintf(w, "  ln %d: fn %s\n", frame.Line, frame.Function)
 	}
+}
 
-	return ErrInterpretRuntimeError
+func (vm *VM) runtimeError(base int, format string, a ...any) error {
+	err := &RuntimeError{Message: fmt.Sprintf(format, a...)}
+	for i := vm.cst - 1; i >= base; i-- {
+		frame := &vm.callStack[i]
+		err.Trace = append(err.Trace, TraceLine{
+			frame.fn.Name,
+			frame.fn.Lines[max(frame.cursor-1, 0)],
+		})
+	}
+	return err
 }
 
 var numOps = map[uint8]func(a, b Number) Value{
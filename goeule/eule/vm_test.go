@@ -0,0 +1,29 @@
+package eule
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestRuntimeErrorTrace(t *testing.T) {
+	source := `var fail(t) {
+  t.missing.x
+}
+print(fail({}),
+  2)
+`
+	vm := New(WithStdout(io.Discard), WithStderr(io.Discard))
+	err := vm.Interpret([]byte(source))
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("got %v, want a runtime error", err)
+	}
+	// the lines are those of the failing instruction and of the call, not
+	// of the instructions following them
+	want := []TraceLine{{"fail", 2}, {"@script", 4}}
+	if !slices.Equal(rerr.Trace, want) {
+		t.Errorf("trace: got %v, want %v", rerr.Trace, want)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"goeule/eule"
+	"io"
+	"os"
+)
+
+var errUnformatted = errors.New("files are not formatted")
+
+func runFmt(args []string) error {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	check := flags.Bool("check", false, "list files whose formatting differs and fail")
+	write := flags.Bool("w", false, "write the result to the files instead of stdout")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		source, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("fmt: %w", err)
+		}
+		formatted, err := eule.Format(source)
+		if err != nil {
+			return err
+		}
+		if *check && !bytes.Equal(source, formatted) {
+			fmt.Println("<stdin>")
+			return errUnformatted
+		}
+		if !*check {
+			os.Stdout.Write(formatted)
+		}
+		return nil
+	}
+
+	var paths []string
+	for _, root := range flags.Args() {
+		found, err := eule.FindTestCases(root)
+		if err != nil {
+			return fmt.Errorf("fmt: %w", err)
+		}
+		paths = append(paths, found...)
+	}
+
+	unformatted := 0
+	var failed error
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("fmt: %w", err)
+		}
+		formatted, err := eule.Format(source)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: not formatted\n", path)
+			failed = err
+			continue
+		}
+		switch {
+		case *check:
+			if !bytes.Equal(source, formatted) {
+				fmt.Println(path)
+				unformatted++
+			}
+		case *write:
+			if !bytes.Equal(source, formatted) {
+				if err := os.WriteFile(path, formatted, 0o644); err != nil {
+					return fmt.Errorf("fmt: %w", err)
+				}
+			}
+		default:
+			os.Stdout.Write(formatted)
+		}
+	}
+	if failed != nil {
+		return failed
+	}
+	if unformatted != 0 {
+		return errUnformatted
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"goeule/eule"
+	"os"
+)
+
+var errLintFailed = errors.New("lint found problems")
+
+func runLint(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	paths, err := findTests(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	// the globals of the VM scripts run in, including those of eule test
+	vm := eule.New(eule.WithFS(eule.HostFS()), eule.WithGlobalLibs(), eule.WithTesting())
+	problems := 0
+	var failed error
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("lint: %w", err)
+		}
+		diags, err := vm.Lint(source)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: not linted\n", path)
+			failed = err
+			continue
+		}
+		for _, d := range diags {
+			fmt.Printf("%s:%d: %s (%s)\n", path, d.Line, d.Message, d.Rule)
+		}
+		problems += len(diags)
+	}
+	if failed != nil {
+		return failed
+	}
+	if problems != 0 {
+		return errLintFailed
+	}
+	return nil
+}
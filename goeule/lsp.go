@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"goeule/eule"
+	"io"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+func runLsp(args []string) error {
+	flags := flag.NewFlagSet("lsp", flag.ContinueOnError)
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	s := &lspServer{
+		in:   textproto.NewReader(bufio.NewReader(os.Stdin)),
+		out:  os.Stdout,
+		vm:   eule.New(eule.WithFS(eule.HostFS()), eule.WithGlobalLibs(), eule.WithTesting()),
+		docs: map[string]*lspDocument{},
+	}
+	return s.serve()
+}
+
+// lspServer speaks the language server protocol with full document sync.
+type lspServer struct {
+	in       *textproto.Reader
+	out      io.Writer
+	vm       *eule.VM
+	docs     map[string]*lspDocument
+	shutdown bool
+}
+
+type lspDocument struct {
+	uri      string
+	text     []byte
+	analysis *eule.Analysis
+}
+
+type lspRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type lspResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result"`
+}
+
+type lspErrorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   lspError        `json:"error"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+const (
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+)
+
+var errLspExit = errors.New("lsp: exit without shutdown")
+
+func (s *lspServer) serve() error {
+	for {
+		req, err := s.read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("lsp: %w", err)
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errLspExit
+			}
+			return nil
+		}
+
+		result, err := s.handle(req)
+		if req.ID == nil {
+			continue // notification
+		}
+		var lerr *lspError
+		switch {
+		case errors.As(err, &lerr):
+			err = s.write(lspErrorResponse{"2.0", req.ID, *lerr})
+		case err != nil:
+			err = s.write(lspErrorResponse{"2.0", req.ID, lspError{lspInvalidParams, err.Error()}})
+		default:
+			err = s.write(lspResponse{"2.0", req.ID, result})
+		}
+		if err != nil {
+			return fmt.Errorf("lsp: %w", err)
+		}
+	}
+}
+
+func (e *lspError) Error() string { return e.Message }
+
+func (s *lspServer) read() (*lspRequest, error) {
+	body, err := readMessage(s.in)
+	if err != nil {
+		return nil, err
+	}
+	var req lspRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (s *lspServer) write(msg any) error {
+	return writeMessage(s.out, msg)
+}
+
+// readMessage reads a message framed by a Content-Length header, as used
+// by both the language server and the debug adapter protocols.
+func readMessage(in *textproto.Reader) ([]byte, error) {
+	header, err := in.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("bad Content-Length: %w", err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(in.R, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func writeMessage(w io.Writer, msg any) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+/* == protocol types ======================================================== */
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type lspPositionParams struct {
+	TextDocument lspTextDocument `json:"textDocument"`
+	Position     lspPosition     `json:"position"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code,omitempty"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspCompletionItem struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type lspDocumentSymbol struct {
+	Name           string   `json:"name"`
+	Detail         string   `json:"detail,omitempty"`
+	Kind           int      `json:"kind"`
+	Range          lspRange `json:"range"`
+	SelectionRange lspRange `json:"selectionRange"`
+}
+
+// completion item kinds and symbol kinds of the protocol
+var (
+	lspCompletionKinds = map[eule.SymbolKind]int{
+		eule.SymbolVariable:  6,
+		eule.SymbolFunction:  3,
+		eule.SymbolParameter: 6,
+		eule.SymbolField:     5,
+		eule.SymbolKeyword:   14,
+	}
+	lspSymbolKinds = map[eule.SymbolKind]int{
+		eule.SymbolVariable: 13,
+		eule.SymbolFunction: 12,
+	}
+)
+
+/* == handlers ============================================================== */
+
+func (s *lspServer) handle(req *lspRequest) (any, error) {
+	switch req.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":       1,
+				"definitionProvider":     true,
+				"referencesProvider":     true,
+				"hoverProvider":          true,
+				"documentSymbolProvider": true,
+				"completionProvider": map[string]any{
+					"triggerCharacters": []string{".", ":", ">"},
+				},
+			},
+			"serverInfo": map[string]any{"name": "eule", "version": eule.Version},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument   lspTextDocument `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n != 0 {
+			return nil, s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, s.publish(params.TextDocument.URI, []lspDiagnostic{})
+
+	case "textDocument/definition":
+		doc, pos, err := s.position(req)
+		if err != nil {
+			return nil, err
+		}
+		def, ok := doc.analysis.Definition(pos)
+		if !ok {
+			return nil, nil
+		}
+		return doc.location(def), nil
+	case "textDocument/references":
+		doc, pos, err := s.position(req)
+		if err != nil {
+			return nil, err
+		}
+		locations := []lspLocation{}
+		for _, ref := range doc.analysis.References(pos) {
+			locations = append(locations, doc.location(ref))
+		}
+		return locations, nil
+	case "textDocument/hover":
+		doc, pos, err := s.position(req)
+		if err != nil {
+			return nil, err
+		}
+		text, ok := doc.analysis.Hover(pos)
+		if !ok {
+			return nil, nil
+		}
+		return map[string]any{
+			"contents": map[string]string{"kind": "plaintext", "value": text},
+		}, nil
+	case "textDocument/completion":
+		doc, pos, err := s.position(req)
+		if err != nil {
+			return nil, err
+		}
+		items := []lspCompletionItem{}
+		for _, c := range doc.analysis.Completions(pos) {
+			items = append(items, lspCompletionItem{c.Label, lspCompletionKinds[c.Kind], c.Detail})
+		}
+		return items, nil
+	case "textDocument/documentSymbol":
+		var params lspPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, err := s.document(params.TextDocument.URI)
+		if err != nil {
+			return nil, err
+		}
+		symbols := []lspDocumentSymbol{}
+		for _, sym := range doc.analysis.Symbols() {
+			start := doc.lspPosition(sym.Start)
+			selection := lspRange{start, doc.lspPosition(eule.Position{
+				Line: sym.Start.Line, Column: sym.Start.Column + len(sym.Name),
+			})}
+			symbols = append(symbols, lspDocumentSymbol{
+				Name:           sym.Name,
+				Detail:         sym.Detail,
+				Kind:           lspSymbolKinds[sym.Kind],
+				Range:          lspRange{start, doc.lspPosition(sym.End)},
+				SelectionRange: selection,
+			})
+		}
+		return symbols, nil
+	}
+	if req.ID == nil {
+		return nil, nil
+	}
+	return nil, &lspError{lspMethodNotFound, "method not found: " + req.Method}
+}
+
+func (s *lspServer) update(uri, text string) error {
+	doc := &lspDocument{uri: uri, text: []byte(text)}
+	doc.analysis = s.vm.Analyze(doc.text)
+	s.docs[uri] = doc
+
+	diags := []lspDiagnostic{}
+	for _, d := range doc.analysis.Diagnostics {
+		diags = append(diags, doc.diagnostic(d))
+	}
+	return s.publish(uri, diags)
+}
+
+func (s *lspServer) publish(uri string, diags []lspDiagnostic) error {
+	return s.write(lspNotification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  map[string]any{"uri": uri, "diagnostics": diags},
+	})
+}
+
+func (s *lspServer) document(uri string) (*lspDocument, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, fmt.Errorf("document not open: %s", uri)
+	}
+	return doc, nil
+}
+
+func (s *lspServer) position(req *lspRequest) (*lspDocument, eule.Position, error) {
+	var params lspPositionParams
+	if err := json.Unmarshal(req.Params, &params); err != nil {
+		return nil, eule.Position{}, err
+	}
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, eule.Position{}, err
+	}
+	return doc, doc.position(params.Position), nil
+}
+
+/* == positions ============================================================= */
+
+// Editors count characters in UTF-16 code units, eule counts bytes.
+
+func (d *lspDocument) line(n int) string {
+	lines := strings.Split(string(d.text), "\n")
+	if n < 0 || n >= len(lines) {
+		return ""
+	}
+	return lines[n]
+}
+
+func (d *lspDocument) position(p lspPosition) eule.Position {
+	line := d.line(p.Line)
+	units, col := 0, 0
+	for col < len(line) && units < p.Character {
+		r, size := utf8.DecodeRuneInString(line[col:])
+		units += utf16.RuneLen(r)
+		col += size
+	}
+	return eule.Position{Line: p.Line + 1, Column: col + 1}
+}
+
+func (d *lspDocument) lspPosition(p eule.Position) lspPosition {
+	line := d.line(p.Line - 1)
+	col := min(max(p.Column-1, 0), len(line))
+	units := 0
+	for _, r := range line[:col] {
+		units += utf16.RuneLen(r)
+	}
+	return lspPosition{p.Line - 1, units}
+}
+
+func (d *lspDocument) location(p eule.Position) lspLocation {
+	start := d.lspPosition(p)
+	end := start
+	line := d.line(p.Line - 1)
+	for col := p.Column - 1; col < len(line) && isWordByte(line[col]); col++ {
+		end.Character++
+	}
+	return lspLocation{d.uri, lspRange{start, end}}
+}
+
+func (d *lspDocument) diagnostic(diag eule.Diagnostic) lspDiagnostic {
+	severity := 2 // warning
+	if diag.Rule == "" {
+		severity = 1 // error
+	}
+	r := lspRange{
+		lspPosition{diag.Line - 1, 0},
+		d.lspPosition(eule.Position{Line: diag.Line, Column: len(d.line(diag.Line-1)) + 1}),
+	}
+	if diag.Column > 0 {
+		r = d.location(eule.Position{Line: diag.Line, Column: diag.Column}).Range
+		if r.End == r.Start {
+			r.End.Character++
+		}
+	}
+	return lspDiagnostic{r, severity, diag.Rule, "eule", diag.Message}
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
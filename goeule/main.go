@@ -1,12 +1,25 @@
 package main
 
 import (
-	"bufio"
+	"errors"
+	"flag"
 	"fmt"
 	"goeule/eule"
-	"io"
-	"log"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// exit codes follow the BSD sysexits convention
+const (
+	exitOk      = 0
+	exitFailure = 1
+	exitUsage   = 64
+	exitCompile = eule.ExitCompileError
+	exitNoInput = 66
+	exitRuntime = eule.ExitRuntimeError
 )
 
 func main() {
@@ -24,39 +37,158 @@ func main() {
 	var err error
 	if len(os.Args) < 2 {
 		err = runRepl()
+	} else if command, ok := commands[os.Args[1]]; ok {
+		err = command(os.Args[2:])
 	} else {
 		err = runFile(os.Args[1:])
 	}
 
-	if err != nil {
-		log.Fatal(err)
-	}
+	os.Exit(exitCode(err))
+}
+
+// newVM creates a VM for the script at path, importing modules from its
+// directory, or from the working directory if path is empty.
+func newVM(path string, args ...string) *eule.VM {
+	return eule.New(
+		eule.WithFS(eule.HostFS()),
+		eule.WithGlobalLibs(),
+		eule.WithArgs(args...),
+		eule.WithEnv(environ()),
+		eule.WithModules(eule.FSLoader(os.DirFS(filepath.Dir(path)))),
+	)
 }
 
-func runFile(args []string) error {
+func runFile(args []string) (err error) {
+	flags := flag.NewFlagSet("eule", flag.ContinueOnError)
+	trace := flags.Bool("trace", false, "trace executed instructions to stderr")
+	traceFn := flags.String("trace-fn", "", "trace only functions with these comma separated `names`")
+	traceLines := flags.String("trace-lines", "", "trace only lines in `from-to` range")
+	profile := flags.String("profile", "", "write a pprof profile to `file` and report the top entries")
+	profileTop := flags.Int("profile-top", 10, "report the top `n` functions and lines")
+	profileRate := flags.Duration("profile-rate", 0, "sample the profile every `interval` instead of counting instructions")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return fmt.Errorf("%w: eule [script] [...arguments]", errUsage)
+	}
+	args = flags.Args()
+
 	scriptPath := args[0]
 	source, err := os.ReadFile(scriptPath)
 	if err != nil {
 		return fmt.Errorf("run file: %w", err)
 	}
-	return eule.New().Interpret(source)
-}
+	vm := newVM(scriptPath, args[1:]...)
 
-func runRepl() error {
-	vm := eule.New()
-	fmt.Printf("eule v%s\n", eule.Version)
-	fmt.Println("exit using ctrl+c")
-	for {
-		fmt.Print("> ")
-		source, err := bufio.NewReader(os.Stdin).ReadBytes('\n')
+	if *trace || *traceFn != "" || *traceLines != "" {
+		filter, err := traceFilter(*traceFn, *traceLines)
 		if err != nil {
-			if err == io.EOF {
-				return nil
+			return err
+		}
+		vm.SetTracer(eule.WriteTrace(os.Stderr), filter)
+	}
+
+	if *profile != "" {
+		if *profileRate > 0 {
+			vm.StartSampling(*profileRate)
+		} else {
+			vm.StartProfile()
+		}
+		defer func() {
+			if perr := writeProfile(vm.StopProfile(), scriptPath, *profile, *profileTop); err == nil {
+				err = perr
 			}
-			return fmt.Errorf("run repl: %w", err)
+		}()
+	}
+
+	if eule.IsBytecode(source) {
+		fn, err := eule.Load(source)
+		if err != nil {
+			return fmt.Errorf("run file: %w", err)
 		}
-		vm.Interpret(source)
+		return vm.Run(fn)
 	}
+	return vm.Interpret(source)
+}
+
+// writeProfile saves p in the pprof format and reports its top entries to
+// stderr.
+func writeProfile(p *eule.Profile, scriptPath, path string, top int) error {
+	p.File = scriptPath
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("profile: %w", err)
+	}
+	if err := p.WritePprof(f); err != nil {
+		f.Close()
+		return fmt.Errorf("profile: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("profile: %w", err)
+	}
+	return p.WriteReport(os.Stderr, top)
+}
+
+func traceFilter(functions, lines string) (eule.TraceFilter, error) {
+	var filter eule.TraceFilter
+	if functions != "" {
+		filter.Functions = strings.Split(functions, ",")
+	}
+	if lines != "" {
+		from, to, _ := strings.Cut(lines, "-")
+		var err error
+		if filter.FromLine, err = strconv.Atoi(from); err != nil {
+			return filter, fmt.Errorf("%w: invalid line range %q", errUsage, lines)
+		}
+		filter.ToLine = filter.FromLine
+		if to != "" {
+			if filter.ToLine, err = strconv.Atoi(to); err != nil {
+				return filter, fmt.Errorf("%w: invalid line range %q", errUsage, lines)
+			}
+		}
+	}
+	return filter, nil
+}
+
+func exitCode(err error) int {
+	var exit *eule.ExitError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOk
+	case errors.As(err, &exit),
+		errors.Is(err, eule.ErrInterpretCompileError),
+		errors.Is(err, eule.ErrInterpretRuntimeError),
+		errors.Is(err, eule.ErrInterrupted):
+		return eule.ExitCode(err)
+	case errors.Is(err, errFlags):
+		return exitUsage
+	case errors.Is(err, errTestsFailed), errors.Is(err, errUnformatted),
+		errors.Is(err, errLintFailed):
+		return exitFailure
+	case errors.Is(err, errRegression):
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	case errors.Is(err, errUsage):
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	case errors.Is(err, fs.ErrNotExist):
+		fmt.Fprintln(os.Stderr, err)
+		return exitNoInput
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+}
+
+func environ() map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	return env
 }
 
 func showHelp() {
@@ -65,10 +197,32 @@ func showHelp() {
 	fmt.Println("usage:")
 	fmt.Println(format("repl", "eule"))
 	fmt.Println(format("file", "eule [script] [...arguments]"))
+	fmt.Println(format("run", "eule run [script] [...arguments]"))
+	fmt.Println(format("disasm", "eule disasm [script]"))
+	fmt.Println(format("tokens", "eule tokens [script]"))
+	fmt.Println(format("compile", "eule compile [-o output] [script]"))
+	fmt.Println(format("test", "eule test [-v] [-unit] [-timeout d] [-cover] [-coverprofile file] [-coverhtml file] [...paths]"))
+	fmt.Println(format("fmt", "eule fmt [-check] [-w] [...paths]"))
+	fmt.Println(format("lint", "eule lint [...paths]"))
+	fmt.Println(format("lsp", "eule lsp (language server on stdio)"))
+	fmt.Println(format("debug", "eule debug (debug adapter on stdio)"))
+	fmt.Println(format("bench", "eule bench [-n runs] [-warmup runs] [-baseline file] [-save file] [...paths]"))
 	fmt.Println()
 	fmt.Println("optional arguments:")
 	fmt.Println(format("--help", "show command line usage"))
 	fmt.Println(format("--version", "show version"))
+	fmt.Println(format("--trace", "trace executed instructions to stderr"))
+	fmt.Println(format("--trace-fn f,g", "trace only the given functions"))
+	fmt.Println(format("--trace-lines a-b", "trace only lines a to b"))
+	fmt.Println(format("--profile file", "write a pprof profile and report hot spots"))
+	fmt.Println(format("--profile-top n", "report the top n functions and lines"))
+	fmt.Println(format("--profile-rate d", "sample the profile every d instead of counting"))
+	fmt.Println()
+	fmt.Println("exit codes:")
+	fmt.Println(format(fmt.Sprint(exitCompile), "compile error"))
+	fmt.Println(format(fmt.Sprint(exitNoInput), "script not found"))
+	fmt.Println(format(fmt.Sprint(exitRuntime), "runtime error"))
+	fmt.Println(format("n", "os.exit(n)"))
 }
 
 func format(arg, desc string) string {
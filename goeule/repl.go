@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"goeule/eule"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	historyFile = ".eule_history"
+	historyShow = 20 // entries :history lists
+)
+
+type repl struct {
+	vm      *eule.VM
+	in      *bufio.Reader
+	history *os.File
+	entries []string // history of this and earlier sessions, oldest first
+}
+
+// runRepl runs the REPL, where modules are imported from the working
+// directory.
+func runRepl() error {
+	r := &repl{vm: newVM(""), in: bufio.NewReader(os.Stdin)}
+	if history, entries, err := openHistory(); err == nil {
+		r.history, r.entries = history, entries
+		defer history.Close()
+	}
+
+	fmt.Printf("eule v%s\n", eule.Version)
+	fmt.Println("type :help for help, exit using ctrl+c")
+	for {
+		source, err := r.read()
+		if err != nil {
+			if err == io.EOF {
+				fmt.Println()
+				return nil
+			}
+			return fmt.Errorf("run repl: %w", err)
+		}
+		if len(bytes.TrimSpace(source)) == 0 {
+			continue
+		}
+		r.remember(source)
+
+		if line := strings.TrimSpace(string(source)); strings.HasPrefix(line, ":") {
+			r.command(line)
+			continue
+		}
+		r.eval(source)
+	}
+}
+
+// read reads lines until they form a complete piece of source.
+func (r *repl) read() ([]byte, error) {
+	var source []byte
+	prompt := "> "
+	for {
+		fmt.Print(prompt)
+		line, err := r.in.ReadBytes('\n')
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+		source = append(source, line...)
+		if err == io.EOF || bytes.HasPrefix(bytes.TrimSpace(source), []byte(":")) ||
+			!eule.Incomplete(source) {
+			return source, nil
+		}
+		prompt = "... "
+	}
+}
+
+func (r *repl) eval(source []byte) {
+	value, err := r.vm.Eval(source)
+	if err != nil {
+		return
+	}
+	if value != (eule.Nihil{}) {
+		fmt.Println(eule.Sprint(value))
+	}
+}
+
+func (r *repl) command(line string) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case ":help":
+		showReplHelp()
+	case ":globals":
+		names := make([]string, 0, len(r.vm.Global.Pairs))
+		for name := range r.vm.Global.Pairs {
+			names = append(names, string(name))
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			fmt.Println(format(name, r.vm.Global.Pairs[eule.String(name)].String()))
+		}
+	case ":disasm":
+		fn, ok := r.vm.Global.Pairs[eule.String(arg)]
+		if !ok {
+			fmt.Printf("variable '%s' is undefined\n", arg)
+			return
+		}
+		if err := eule.Disassemble(os.Stdout, fn); err != nil {
+			fmt.Println(err)
+		}
+	case ":load":
+		source, err := os.ReadFile(arg)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := r.vm.Interpret(source); err != nil {
+			// the VM reported the details of compile and runtime errors
+			var rerr *eule.RuntimeError
+			if errors.As(err, &rerr) {
+				err = eule.ErrInterpretRuntimeError
+			}
+			fmt.Printf("%s: %v\n", arg, err)
+		}
+	case ":history":
+		for i := max(len(r.entries)-historyShow, 0); i < len(r.entries); i++ {
+			fmt.Printf("%4d  %s\n", i+1, strings.ReplaceAll(r.entries[i], "\n", "\n      "))
+		}
+	case ":reset":
+		r.vm = newVM("")
+	default:
+		fmt.Printf("unknown command %s, see :help\n", name)
+	}
+}
+
+// remember adds source to the history, saving it as a quoted line.
+func (r *repl) remember(source []byte) {
+	entry := strings.TrimRight(string(source), "\r\n")
+	r.entries = append(r.entries, entry)
+	if r.history != nil {
+		fmt.Fprintln(r.history, strconv.Quote(entry))
+	}
+}
+
+// openHistory opens the history file for appending and returns the entries
+// it holds.
+func openHistory() (*os.File, []string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	path := filepath.Join(home, historyFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, parseHistory(data), nil
+}
+
+// parseHistory returns the entries of a history file. Lines that are not
+// quoted were saved with only their newlines escaped.
+func parseHistory(data []byte) []string {
+	var entries []string
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimRight(line, "\r\n")
+		entry, err := strconv.Unquote(line)
+		if err != nil {
+			entry = strings.ReplaceAll(line, "\\n", "\n")
+		}
+		if entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func showReplHelp() {
+	fmt.Println("commands:")
+	fmt.Println(format(":help", "show this help"))
+	fmt.Println(format(":globals", "list global variables"))
+	fmt.Println(format(":disasm <fn>", "show bytecode of global function"))
+	fmt.Println(format(":load <file>", "run file in this session"))
+	fmt.Println(format(":reset", "start a fresh session"))
+	fmt.Println(format(":history", "list the last entries of the history"))
+	fmt.Println()
+	fmt.Printf("history is kept in ~/%s\n", historyFile)
+}
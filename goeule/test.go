@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"goeule/eule"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var errTestsFailed = errors.New("tests failed")
+
+func runTest(args []string) (err error) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "report passing tests too")
+	timeout := flags.Duration("timeout", eule.DefaultTestTimeout, "default per test `timeout`")
+	unit := flags.Bool("unit", false, "run tests registered with test() instead of output checks")
+	cover := flags.Bool("cover", false, "report the lines the tests cover")
+	coverProfile := flags.String("coverprofile", "coverage.lcov", "write the LCOV coverage to `file` if -cover is set")
+	coverHTML := flags.String("coverhtml", "coverage.html", "write the HTML coverage report to `file` if -cover is set")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	paths, err := findTests(flags.Args())
+	if err != nil {
+		return err
+	}
+	var coverage *eule.Coverage
+	if *cover {
+		coverage = eule.NewCoverage()
+		defer func() {
+			if cerr := writeCoverage(coverage, *coverProfile, *coverHTML); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+	}
+	if *unit {
+		return runUnitTests(paths, *verbose, coverage)
+	}
+
+	failed := 0
+	start := time.Now()
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("test: %w", err)
+		}
+		tc, err := eule.ParseTestCase(path, source)
+		if err != nil {
+			return fmt.Errorf("test: %w", err)
+		}
+		if tc.Timeout == 0 {
+			tc.Timeout = *timeout
+		}
+
+		r := tc.Run(testOptions(path, coverage)...)
+		if r.Passed() {
+			if *verbose {
+				fmt.Printf("%s -> ok (%s)\n", path, r.Elapsed.Round(time.Millisecond))
+			}
+			continue
+		}
+		failed++
+		fmt.Printf("%s -> error: %s\n", path, strings.TrimRight(r.Failure, "\n"))
+	}
+
+	fmt.Printf("%d tests, %d failed (%s)\n", len(paths), failed, time.Since(start).Round(time.Millisecond))
+	if failed != 0 {
+		return errTestsFailed
+	}
+	return nil
+}
+
+func findTests(roots []string) ([]string, error) {
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	var paths []string
+	for _, root := range roots {
+		found, err := eule.FindTestCases(root)
+		if err != nil {
+			return nil, fmt.Errorf("test: %w", err)
+		}
+		paths = append(paths, found...)
+	}
+	return paths, nil
+}
+
+func runUnitTests(paths []string, verbose bool, coverage *eule.Coverage) error {
+	passed, failed := 0, 0
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("test: %w", err)
+		}
+		fmt.Println(path)
+		results, err := eule.RunUnitTests(source, testOptions(path, coverage)...)
+		if err != nil {
+			failed++
+			fmt.Printf("  FAIL %s\n", err)
+			continue
+		}
+		for _, r := range results {
+			if r.Passed() {
+				passed++
+				if verbose {
+					fmt.Printf("  ok   %s (%s)\n", r.Name, r.Elapsed.Round(time.Microsecond))
+				}
+				continue
+			}
+			failed++
+			fmt.Printf("  FAIL %s (ln %d)\n", r.Name, r.Line)
+			fmt.Printf("       ln %d: %s\n", r.FailureLine, r.Failure)
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	if failed != 0 {
+		return errTestsFailed
+	}
+	return nil
+}
+
+func testOptions(path string, coverage *eule.Coverage) []eule.Option {
+	opts := []eule.Option{
+		eule.WithFS(eule.HostFS()),
+		eule.WithGlobalLibs(),
+		eule.WithModules(eule.FSLoader(os.DirFS(filepath.Dir(path)))),
+	}
+	if coverage != nil {
+		opts = append(opts, eule.WithCoverage(coverage, path))
+	}
+	return opts
+}
+
+// writeCoverage prints the coverage summary and writes the LCOV and HTML
+// reports to the paths that are not empty.
+func writeCoverage(coverage *eule.Coverage, lcov, html string) error {
+	fmt.Println("coverage:")
+	coverage.WriteSummary(os.Stdout)
+	for _, report := range []struct {
+		path  string
+		write func(io.Writer) error
+	}{{lcov, coverage.WriteLCOV}, {html, coverage.WriteHTML}} {
+		if report.path == "" {
+			continue
+		}
+		f, err := os.Create(report.path)
+		if err != nil {
+			return fmt.Errorf("test: %w", err)
+		}
+		err = report.write(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("test: %w", err)
+		}
+	}
+	return nil
+}
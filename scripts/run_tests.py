@@ -16,8 +16,9 @@ def read_out(file: pathlib.Path) -> list[str]:
     out: list[str] = []
     with open(file, "r", encoding="utf-8") as f:
         for line in f.readlines():
-            if match := re.search(r"# out:(.+)", line):
-                out.append(match.group(1).strip())
+            # one space follows the colon, the rest is output
+            if match := re.search(r"# out: ?(.*)", line):
+                out.append(match.group(1).rstrip())
     return out
 
 